@@ -0,0 +1,39 @@
+// cmd/ask/agent.go
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/acazau/shell-ask-go/internal/agent"
+	"github.com/acazau/shell-ask-go/internal/cli"
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/pkg/utils"
+)
+
+// runAgent answers the request with the agent, which lets the model call
+// the built-in tools once the user approved each call
+func runAgent(ctx context.Context, provider providers.Provider, req *providers.Request) error {
+	caller, ok := provider.(providers.ToolCaller)
+	if !ok {
+		return fmt.Errorf("%s does not support tool calling, use an OpenAI, Anthropic, Gemini or Ollama model with --agent", provider.Name())
+	}
+
+	answer, usage, err := agent.New(caller, approveToolCall).Run(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to complete request: %w", providers.ClassifyError(provider.Name(), err))
+	}
+
+	fmt.Println(answer)
+	reportUsage(req, usage)
+	return nil
+}
+
+// approveToolCall asks the user to approve a tool call. Piped input takes
+// stdin, so the question goes to the terminal instead.
+func approveToolCall(question string) (bool, error) {
+	if utils.IsPiped() {
+		return utils.AskYesNoTTY(question)
+	}
+	return cli.AskYesNo(question)
+}
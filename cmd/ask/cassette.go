@@ -0,0 +1,50 @@
+// cmd/ask/cassette.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/acazau/shell-ask-go/internal/cassette"
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/httpclient"
+	"github.com/spf13/cobra"
+)
+
+// useCassettes makes all HTTP traffic go through a recorder for --record or
+// SHELL_ASK_RECORD, or a replayer for --replay or SHELL_ASK_REPLAY
+func useCassettes(cmd *cobra.Command, cfg *config.Config) error {
+	record, _ := cmd.Flags().GetString("record")
+	if record == "" {
+		record = os.Getenv("SHELL_ASK_RECORD")
+	}
+	replay, _ := cmd.Flags().GetString("replay")
+	if replay == "" {
+		replay = os.Getenv("SHELL_ASK_REPLAY")
+	}
+
+	switch {
+	case record != "" && replay != "":
+		return fmt.Errorf("recording and replaying cannot be combined")
+	case record != "":
+		// The headers are added on top of the recorder, once
+		settings := cfg.HTTP
+		settings.Headers = nil
+		base, err := httpclient.NewTransport(settings)
+		if err != nil {
+			return fmt.Errorf("invalid http config: %w", err)
+		}
+		recorder, err := cassette.NewRecorder(record, base)
+		if err != nil {
+			return err
+		}
+		cfg.HTTP.Transport = recorder
+	case replay != "":
+		replayer, err := cassette.NewReplayer(replay)
+		if err != nil {
+			return err
+		}
+		cfg.HTTP.Transport = replayer
+	}
+	return nil
+}
@@ -9,7 +9,6 @@ import (
 	"strings"
 
 	"github.com/acazau/shell-ask-go/internal/config"
-	"github.com/acazau/shell-ask-go/internal/models"
 	"github.com/acazau/shell-ask-go/internal/providers"
 	"github.com/acazau/shell-ask-go/pkg/utils"
 	"github.com/spf13/cobra"
@@ -90,19 +89,11 @@ func (cli *CLI) handleAsk(cmd *cobra.Command, args []string) error {
 }
 
 func (cli *CLI) getProvider(modelID string) (providers.Provider, error) {
-	model := models.SelectModel(modelID)
-	switch {
-	case strings.HasPrefix(model, "gpt"):
-		return providers.NewOpenAIProvider(cli.config.OpenAIKey, model)
-	case strings.HasPrefix(model, "claude"):
-		return providers.NewAnthropicProvider(cli.config.AnthropicKey, model), nil
-	default:
-		return nil, fmt.Errorf("unsupported model: %s", model)
-	}
+	return providers.InitializeProviderWithConfig(cli.config, modelID)
 }
 
 func (cli *CLI) processRequest(ctx context.Context, provider providers.Provider, prompt string, stream bool) error {
-	reader, err := provider.Complete(ctx, prompt, stream)
+	reader, err := provider.Complete(ctx, providers.NewRequest(prompt, stream))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,360 @@
+// cmd/ask/compare.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/pkg/chat"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const (
+	// compareMinColumn is the narrowest column of the side-by-side layout.
+	// Narrower terminals get one section per model.
+	compareMinColumn = 40
+	// compareGutter separates the columns
+	compareGutter = " │ "
+)
+
+// comparison is the run of one model of --compare
+type comparison struct {
+	model    string
+	provider providers.Provider
+	req      *providers.Request
+	output   *liveBuffer
+
+	// usage and err are set once output is closed
+	usage providers.Usage
+	err   error
+}
+
+// runCompare sends the same request to every model at once and prints the
+// answers in labeled sections, or side by side on a wide terminal, followed
+// by the latency, tokens and cost of each model. confirm asks before large
+// inputs are sent, as for a single model.
+func runCompare(cmd *cobra.Command, cfg *config.Config, modelIDs []string, base *providers.Request, confirm bool) error {
+	runs, wait, err := startComparisons(cmd, cfg, modelIDs, base, confirm)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if width := compareColumnWidth(out, len(runs)); width > 0 {
+		wait()
+		writeColumns(out, runs, width)
+	} else {
+		writeSections(out, runs)
+	}
+	wait()
+	writeComparison(out, runs)
+
+	for _, run := range runs {
+		if run.err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("every model failed: %w", runs[0].err)
+}
+
+// startComparisons sends a copy of base to each model at once and returns
+// the runs and a function waiting for all of them. Models that cannot be
+// set up fail on their own. The budgets are checked on the estimated costs
+// of all the runs added up, and of the estimated requests of extra sent
+// after them.
+func startComparisons(cmd *cobra.Command, cfg *config.Config, modelIDs []string, base *providers.Request, confirm bool, extra ...*spendTracker) ([]*comparison, func(), error) {
+	runs := make([]*comparison, len(modelIDs))
+	var total config.Pricing
+	for i, modelID := range modelIDs {
+		run := &comparison{model: modelID, output: newLiveBuffer()}
+		runs[i] = run
+
+		run.provider, run.err = providers.InitializeProviderWithConfig(cfg, modelID)
+		if run.err != nil {
+			continue
+		}
+		run.req = &providers.Request{
+			System:   base.System,
+			Messages: append([]chat.Message(nil), base.Messages...),
+			Stream:   base.Stream,
+			Options:  generationOptions(cmd, cfg, modelID),
+		}
+		if pricing := modelPricing(cfg, modelID); pricing != nil {
+			if _, ok := cfg.FallbackChain(modelID); !ok {
+				run.req.Pricing = pricing
+			}
+			total.Input += pricing.Input
+			total.Output += pricing.Output
+		}
+	}
+
+	prompt := base.SystemPrompt()
+	for _, msg := range base.Turns() {
+		prompt += msg.Content
+	}
+	if confirm {
+		if err := confirmCost(cmd, cfg, &total, prompt); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	spends := append([]*spendTracker(nil), extra...)
+	for _, run := range runs {
+		if run.err != nil {
+			continue
+		}
+		var spend *spendTracker
+		spend, run.err = newSpendTracker(cmd, cfg, run.provider, run.model, modelPricing(cfg, run.model))
+		if run.err != nil {
+			continue
+		}
+		spend.estimate(cmd, prompt)
+		run.req.OnUsage = spend.record
+		run.req.BeforeAttempt = spend.checkAttempt
+		spends = append(spends, spend)
+	}
+	// The runs are sent at once, so they must fit the budgets together
+	if err := checkBudgets(spends); err != nil {
+		return nil, nil, err
+	}
+
+	var wg sync.WaitGroup
+	for _, run := range runs {
+		if run.err != nil {
+			run.output.close()
+			continue
+		}
+
+		wg.Add(1)
+		go func(run *comparison) {
+			defer wg.Done()
+			run.complete(cmd)
+		}(run)
+	}
+	return runs, wg.Wait, nil
+}
+
+// complete copies the answer into the output as it arrives
+func (c *comparison) complete(cmd *cobra.Command) {
+	defer c.output.close()
+	reader, err := c.provider.Complete(cmd.Context(), c.req)
+	if err != nil {
+		c.err = providers.ClassifyError(c.provider.Name(), err)
+		return
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(c.output, reader)
+	reporter, reported := reader.(providers.UsageReporter)
+	if reported {
+		c.usage = reporter.Usage()
+	}
+	// The tokens of an answer cut short by a failure or Ctrl-C were still
+	// used
+	if c.req.OnUsage != nil && (err == nil || reported) {
+		c.req.OnUsage(c.usage)
+	}
+	if err != nil {
+		c.err = providers.ClassifyError(c.provider.Name(), err)
+	}
+}
+
+// compareColumnWidth returns the column width for answers side by side, or
+// 0 if out is not a terminal wide enough for them
+func compareColumnWidth(out io.Writer, columns int) int {
+	file, ok := out.(*os.File)
+	if !ok || columns < 2 {
+		return 0
+	}
+	fd := int(file.Fd())
+	if !term.IsTerminal(fd) {
+		return 0
+	}
+	width, _, err := term.GetSize(fd)
+	if err != nil {
+		return 0
+	}
+	column := (width - (columns-1)*utf8.RuneCountInString(compareGutter)) / columns
+	if column < compareMinColumn {
+		return 0
+	}
+	return column
+}
+
+// writeSections prints the answers one after the other. The answer of the
+// current section streams in while the later ones are buffered.
+func writeSections(out io.Writer, runs []*comparison) {
+	for i, run := range runs {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "=== %s ===\n", run.model)
+		if run.output.follow(out) > 0 {
+			fmt.Fprintln(out)
+		}
+		if run.err != nil {
+			fmt.Fprintf(out, "Error: %v\n", run.err)
+		}
+	}
+}
+
+// writeColumns prints the finished answers side by side
+func writeColumns(out io.Writer, runs []*comparison, width int) {
+	columns := make([][]string, len(runs))
+	header := make([]string, len(runs))
+	rule := make([]string, len(runs))
+	rows := 0
+	for i, run := range runs {
+		text := strings.TrimRight(run.output.String(), "\n")
+		if run.err != nil {
+			text = strings.TrimLeft(text+"\nError: "+run.err.Error(), "\n")
+		}
+		columns[i] = wrapText(text, width)
+		rows = max(rows, len(columns[i]))
+		header[i] = pad(truncate(run.model, width), width)
+		rule[i] = strings.Repeat("─", width)
+	}
+
+	fmt.Fprintln(out, strings.TrimRight(strings.Join(header, compareGutter), " "))
+	fmt.Fprintln(out, strings.Join(rule, "─┼─"))
+	for row := 0; row < rows; row++ {
+		cells := make([]string, len(columns))
+		for i, lines := range columns {
+			var line string
+			if row < len(lines) {
+				line = lines[row]
+			}
+			cells[i] = pad(line, width)
+		}
+		fmt.Fprintln(out, strings.TrimRight(strings.Join(cells, compareGutter), " "))
+	}
+}
+
+// writeComparison prints the latency, tokens and cost of each model
+func writeComparison(out io.Writer, runs []*comparison) {
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Model\tLatency\tInput\tOutput\tCost")
+	for _, run := range runs {
+		if run.err != nil && run.usage.Model == "" {
+			var classified *providers.Error
+			reason := "failed"
+			if errors.As(run.err, &classified) {
+				reason = classified.Kind.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\n", run.model, reason)
+			continue
+		}
+
+		cost := "-"
+		if run.req.Pricing != nil {
+			cost = fmt.Sprintf("$%.4f", run.req.Pricing.Cost(run.usage.InputTokens, run.usage.OutputTokens))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", run.model, run.usage.Latency.Round(time.Millisecond),
+			run.usage.InputTokens, run.usage.OutputTokens, cost)
+	}
+	w.Flush()
+}
+
+// wrapText breaks text into lines of at most width characters, at spaces
+// where possible
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(strings.ReplaceAll(line, "\t", "    "))
+		for len(runes) > width {
+			cut := width
+			for i := width; i > 0; i-- {
+				if runes[i] == ' ' {
+					cut = i
+					break
+				}
+			}
+			lines = append(lines, strings.TrimRight(string(runes[:cut]), " "))
+			runes = []rune(strings.TrimLeft(string(runes[cut:]), " "))
+		}
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
+
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+func truncate(s string, width int) string {
+	if runes := []rune(s); len(runes) > width {
+		return string(runes[:width-1]) + "…"
+	}
+	return s
+}
+
+// liveBuffer collects an answer while it arrives and lets one reader follow
+// it until it is closed
+type liveBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+func newLiveBuffer() *liveBuffer {
+	b := &liveBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *liveBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+func (b *liveBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// follow copies the data to out as it is written until the buffer is
+// closed, and returns the number of bytes copied
+func (b *liveBuffer) follow(out io.Writer) int {
+	offset := 0
+	for {
+		b.mu.Lock()
+		for offset == len(b.data) && !b.closed {
+			b.cond.Wait()
+		}
+		chunk := b.data[offset:]
+		closed := b.closed
+		b.mu.Unlock()
+
+		out.Write(chunk)
+		offset += len(chunk)
+		if closed {
+			return offset
+		}
+	}
+}
+
+func (b *liveBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
@@ -0,0 +1,238 @@
+// cmd/ask/consensus.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/internal/schema"
+	"github.com/spf13/cobra"
+)
+
+// defaultConsensus is the number of answers of --judge without --consensus
+// or --compare
+const defaultConsensus = 3
+
+// judgeType is the shape of the verdict of the judge
+const judgeType = "{answer: string, agree: boolean, disagreements: string[]}"
+
+const judgeInstruction = `Several assistants answered the same request independently. Compare their answers, then select the best one or merge them into a single better answer. Keep the form the request asks for, such as a command only. Set agree to false and list the disagreements if the answers differ in substance, not only in wording.`
+
+// panelAnswer is an answer of one member of the panel
+type panelAnswer struct {
+	label string
+	text  string
+}
+
+// verdict is the answer of the judge
+type verdict struct {
+	Answer        string   `json:"answer"`
+	Agree         bool     `json:"agree"`
+	Disagreements []string `json:"disagreements"`
+}
+
+// consensusPanel returns the models answering a consensus request: size
+// answers taken in turn from the --compare models, or all from the selected
+// model without them. size 0 means one answer per --compare model.
+func consensusPanel(modelID string, compare []string, size int) []string {
+	if len(compare) == 0 {
+		compare = []string{modelID}
+		if size == 0 {
+			size = defaultConsensus
+		}
+	}
+	if size == 0 {
+		size = len(compare)
+	}
+	panel := make([]string, size)
+	for i := range panel {
+		panel[i] = compare[i%len(compare)]
+	}
+	return panel
+}
+
+// runConsensus has every model of the panel answer base independently, then
+// prints the answer chosen or merged by the judge model, or the majority
+// answer if there is no judge. How far the answers agree goes to stderr.
+func runConsensus(cmd *cobra.Command, cfg *config.Config, panel []string, judge string, base *providers.Request, confirm bool) error {
+	// The answers are compared once complete
+	base.Stream = false
+
+	// The judge is set up first, so the budgets are checked on the panel and
+	// the judge together before any answer is paid for. Its estimate only
+	// covers the request, as the answers are not known yet.
+	var judgeProvider providers.Provider
+	var judgeSpend *spendTracker
+	var extra []*spendTracker
+	if judge != "" {
+		var err error
+		judgeProvider, err = providers.InitializeProviderWithConfig(cfg, judge)
+		if err != nil {
+			return fmt.Errorf("failed to initialize judge: %w", err)
+		}
+		judgeSpend, err = newSpendTracker(cmd, cfg, judgeProvider, judge, modelPricing(cfg, judge))
+		if err != nil {
+			return err
+		}
+		judgeSpend.estimate(cmd, judgeInstruction+judgePrompt(base, nil))
+		extra = append(extra, judgeSpend)
+	}
+
+	runs, wait, err := startComparisons(cmd, cfg, panel, base, confirm, extra...)
+	if err != nil {
+		return err
+	}
+	wait()
+
+	stderr := cmd.ErrOrStderr()
+	showUsage, _ := cmd.Flags().GetBool("usage")
+	var answers []panelAnswer
+	var firstErr error
+	for i, run := range runs {
+		label := fmt.Sprintf("answer %d (%s)", i+1, run.model)
+		if run.err != nil {
+			fmt.Fprintf(stderr, "Warning: %s failed: %v\n", label, run.err)
+			if firstErr == nil {
+				firstErr = run.err
+			}
+			continue
+		}
+		if showUsage {
+			fmt.Fprintln(stderr, providers.FormatUsage(run.usage, run.req.Pricing))
+		}
+		answers = append(answers, panelAnswer{label: label, text: strings.TrimSpace(run.output.String())})
+	}
+	if len(answers) == 0 {
+		return fmt.Errorf("every model failed: %w", firstErr)
+	}
+
+	if judge == "" {
+		writeVote(cmd.OutOrStdout(), stderr, answers)
+		return nil
+	}
+	return runJudge(cmd, cfg, judge, judgeProvider, judgeSpend, base, answers)
+}
+
+// writeVote prints the command most answers agree on, the first of them on
+// a tie, and how the others differ
+func writeVote(out, stderr io.Writer, answers []panelAnswer) {
+	// Commands are compared without fences and whitespace differences
+	groups := make(map[string][]panelAnswer)
+	var order []string
+	for _, answer := range answers {
+		answer.text = stripFences(answer.text)
+		key := strings.Join(strings.Fields(answer.text), " ")
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], answer)
+	}
+	winner := order[0]
+	for _, key := range order[1:] {
+		if len(groups[key]) > len(groups[winner]) {
+			winner = key
+		}
+	}
+
+	fmt.Fprintln(out, groups[winner][0].text)
+
+	switch agreeing := len(groups[winner]); {
+	case len(order) == 1:
+		fmt.Fprintf(stderr, "Consensus: all %d answers agree\n", len(answers))
+		return
+	case agreeing == 1:
+		fmt.Fprintf(stderr, "No consensus: every answer differs, showing %s. The others answered:\n", groups[winner][0].label)
+	default:
+		fmt.Fprintf(stderr, "Consensus: %d of %d answers agree. The others answered:\n", agreeing, len(answers))
+	}
+	for _, key := range order {
+		if key == winner {
+			continue
+		}
+		for _, answer := range groups[key] {
+			fmt.Fprintf(stderr, "  %s: %s\n", answer.label, answer.text)
+		}
+	}
+}
+
+// stripFences removes the Markdown code fence around an answer
+func stripFences(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	lines = lines[1:]
+	if last := len(lines) - 1; last >= 0 && strings.HasPrefix(strings.TrimSpace(lines[last]), "```") {
+		lines = lines[:last]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// runJudge asks the judge model to select or merge the answers and prints
+// its answer, with the disagreements it found on stderr. spend is checked
+// again with the answers, whose spend is in the ledger by now.
+func runJudge(cmd *cobra.Command, cfg *config.Config, judge string, provider providers.Provider, spend *spendTracker, base *providers.Request, answers []panelAnswer) error {
+	shape, err := schema.Parse(judgeType)
+	if err != nil {
+		return err
+	}
+
+	req := providers.NewRequest(judgePrompt(base, answers), false)
+	req.System = judgeInstruction
+	req.Options = generationOptions(cmd, cfg, judge)
+	req.ShowUsage, _ = cmd.Flags().GetBool("usage")
+	if _, ok := cfg.FallbackChain(judge); !ok {
+		req.Pricing = modelPricing(cfg, judge)
+	}
+	if err := spend.check(cmd, req.System+req.Messages[0].Content); err != nil {
+		return err
+	}
+	req.OnUsage = spend.record
+	req.BeforeAttempt = spend.checkAttempt
+
+	var total providers.Usage
+	value, err := completeStructured(cmd.Context(), provider, req, shape, &total)
+	reportUsage(req, total)
+	if err != nil {
+		return fmt.Errorf("judge %s: %w", judge, err)
+	}
+	var result verdict
+	if err := json.Unmarshal(value, &result); err != nil {
+		return fmt.Errorf("judge %s: %w", judge, err)
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout(), strings.TrimSpace(result.Answer))
+
+	stderr := cmd.ErrOrStderr()
+	if result.Agree && len(result.Disagreements) == 0 {
+		fmt.Fprintf(stderr, "Judge %s: the %d answers agree\n", judge, len(answers))
+		return nil
+	}
+	fmt.Fprintf(stderr, "Judge %s: the answers disagree\n", judge)
+	for _, disagreement := range result.Disagreements {
+		fmt.Fprintf(stderr, "  - %s\n", disagreement)
+	}
+	return nil
+}
+
+// judgePrompt presents the request and the answers to the judge
+func judgePrompt(base *providers.Request, answers []panelAnswer) string {
+	var prompt strings.Builder
+	if system := base.SystemPrompt(); system != "" {
+		fmt.Fprintf(&prompt, "System prompt of the request:\n%s\n\n", system)
+	}
+	prompt.WriteString("Request:\n")
+	for _, msg := range base.Turns() {
+		prompt.WriteString(msg.Content)
+		prompt.WriteString("\n")
+	}
+	for _, answer := range answers {
+		fmt.Fprintf(&prompt, "\nThe %s:\n%s\n", answer.label, answer.text)
+	}
+	return prompt.String()
+}
@@ -0,0 +1,229 @@
+// cmd/ask/dryrun.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/acazau/shell-ask-go/internal/agent"
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/copilot"
+	"github.com/acazau/shell-ask-go/internal/httpclient"
+	"github.com/acazau/shell-ask-go/internal/models"
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/pkg/utils"
+)
+
+// errDryRun fails the requests of a dry run instead of sending them
+var errDryRun = errors.New("dry run, request not sent")
+
+// dryRunToken stands in for the credentials a provider exchanges for before
+// it can build its request
+const dryRunToken = "dry-run-token"
+
+// dryRunMode holds what runAsk would do with the request
+type dryRunMode struct {
+	capture *dryRunTransport
+	shape   map[string]interface{}
+	agent   bool
+	// imageURLs are the --image URLs that were not fetched
+	imageURLs []string
+}
+
+// capturedRequest is a request a provider tried to send, with its secrets
+// redacted
+type capturedRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// dryRunTransport records the requests of a dry run and fails them, so
+// nothing leaves the machine. The Copilot token exchange is answered with a
+// placeholder token instead, so the chat request can still be built.
+type dryRunTransport struct {
+	mu       sync.Mutex
+	requests []capturedRequest
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.String() == copilot.TokenAPI {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"token":"` + dryRunToken + `"}`)),
+			Request:    req,
+		}, nil
+	}
+
+	captured := capturedRequest{
+		Method: req.Method,
+		URL:    httpclient.RedactURL(req.URL),
+		Header: httpclient.RedactHeader(req.Header),
+	}
+	if req.Body != nil {
+		captured.Body, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = append(t.requests, captured)
+	return nil, errDryRun
+}
+
+func (t *dryRunTransport) captured() []capturedRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.requests
+}
+
+// notFetched stands in for the content of URLs in a dry run
+func notFetched(urls []string) string {
+	parts := make([]string, len(urls))
+	for i, url := range urls {
+		parts[i] = fmt.Sprintf("=== %s ===\n[not fetched in a dry run]", url)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// splitURLs separates the http(s) URLs from the file paths of sources
+func splitURLs(sources []string) (paths, urls []string) {
+	for _, source := range sources {
+		if utils.IsURL(strings.TrimSpace(source)) {
+			urls = append(urls, source)
+		} else {
+			paths = append(paths, source)
+		}
+	}
+	return paths, urls
+}
+
+// runDryRun prints the assembled request and the HTTP request the provider
+// would send for it, without sending anything
+func runDryRun(ctx context.Context, out io.Writer, cfg *config.Config, provider providers.Provider, modelID string, req *providers.Request, mode dryRunMode) error {
+	caller, isToolCaller := provider.(providers.ToolCaller)
+	if mode.agent {
+		if !isToolCaller {
+			return fmt.Errorf("%s does not support tool calling, use an OpenAI, Anthropic, Gemini or Ollama model with --agent", provider.Name())
+		}
+		for _, tool := range agent.Builtin() {
+			req.Tools = append(req.Tools, tool.Tool)
+		}
+	}
+	if mode.shape != nil {
+		// As in runStructured
+		req.Schema = mode.shape
+		req.Stream = false
+	}
+
+	// The provider builds its request and fails once it tries to send it
+	var err error
+	if mode.agent {
+		_, err = caller.CompleteWithTools(ctx, req)
+	} else {
+		_, _, err = completeText(ctx, provider, req)
+	}
+	requests := mode.capture.captured()
+	if len(requests) == 0 && err != nil {
+		return err
+	}
+
+	writeDryRunRequest(out, cfg, provider, modelID, req, mode)
+	for _, captured := range requests {
+		writeCapturedRequest(out, captured)
+	}
+	writeDryRunEstimate(out, cfg, modelID, req)
+	return nil
+}
+
+func writeDryRunRequest(out io.Writer, cfg *config.Config, provider providers.Provider, modelID string, req *providers.Request, mode dryRunMode) {
+	fmt.Fprintf(out, "Provider: %s\n", provider.Name())
+	if chain, ok := cfg.FallbackChain(modelID); ok {
+		fmt.Fprintf(out, "Model: %s (fallback chain of %s, the first model is shown)\n", modelID, strings.Join(chain, ", "))
+	} else if _, model, err := providers.Resolve(cfg, modelID); err == nil {
+		fmt.Fprintf(out, "Model: %s\n", model)
+	}
+	fmt.Fprintf(out, "Stream: %t\n", req.Stream)
+	if options, _ := json.Marshal(req.Options); string(options) != "{}" {
+		fmt.Fprintf(out, "Parameters: %s\n", options)
+	}
+	if req.Schema != nil {
+		schema, _ := json.Marshal(req.Schema)
+		fmt.Fprintf(out, "Schema: %s\n", schema)
+	}
+	if len(req.Tools) > 0 {
+		names := make([]string, len(req.Tools))
+		for i, tool := range req.Tools {
+			names[i] = tool.Name
+		}
+		fmt.Fprintf(out, "Tools: %s\n", strings.Join(names, ", "))
+	}
+
+	if system := req.SystemPrompt(); system != "" {
+		fmt.Fprintf(out, "\n[system]\n%s\n", system)
+	}
+	for _, msg := range req.Turns() {
+		fmt.Fprintf(out, "\n[%s]\n%s\n", msg.Role, msg.Content)
+		for _, image := range msg.Images {
+			fmt.Fprintf(out, "[image %s, %d bytes]\n", image.MIMEType, len(image.Data))
+		}
+	}
+	for _, url := range mode.imageURLs {
+		fmt.Fprintf(out, "[image %s, not fetched in a dry run]\n", url)
+	}
+}
+
+func writeCapturedRequest(out io.Writer, captured capturedRequest) {
+	fmt.Fprintf(out, "\n%s %s\n", captured.Method, captured.URL)
+	names := make([]string, 0, len(captured.Header))
+	for name := range captured.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "%s: %s\n", name, strings.Join(captured.Header[name], ", "))
+	}
+	if len(captured.Body) == 0 {
+		return
+	}
+
+	var body bytes.Buffer
+	if json.Indent(&body, captured.Body, "", "  ") != nil {
+		body.Reset()
+		body.Write(captured.Body)
+	}
+	fmt.Fprintf(out, "\n%s\n", body.String())
+}
+
+// writeDryRunEstimate prints the estimated input tokens of the request and
+// their cost, if the price is known
+func writeDryRunEstimate(out io.Writer, cfg *config.Config, modelID string, req *providers.Request) {
+	text := req.SystemPrompt()
+	for _, msg := range req.Turns() {
+		text += msg.Content
+	}
+	tokens := models.EstimateTokens(text)
+
+	estimate := fmt.Sprintf("\nEstimated input tokens: %d", tokens)
+	if pricing := modelPricing(cfg, modelID); pricing != nil {
+		estimate += fmt.Sprintf(" (about $%.4f)", pricing.Cost(tokens, 0))
+	}
+	fmt.Fprintln(out, estimate)
+}
@@ -0,0 +1,33 @@
+// cmd/ask/history.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/pkg/chat"
+)
+
+// saveHistory keeps the conversation of an answer cut short by Ctrl-C as far
+// as it got, marked as interrupted. Complete and failed answers are not kept.
+// A failure only warns, as the answer was already printed.
+func saveHistory(modelID string, req *providers.Request, answer string, err error) {
+	if !errors.Is(err, context.Canceled) {
+		return
+	}
+
+	conversation := &chat.Chat{
+		Messages:    append(append([]chat.Message(nil), req.Messages...), chat.Message{Role: chat.RoleAssistant, Content: answer}),
+		Model:       modelID,
+		Interrupted: true,
+	}
+	if req.System != "" {
+		conversation.Messages = append([]chat.Message{{Role: chat.RoleSystem, Content: req.System}}, conversation.Messages...)
+	}
+	if err := chat.SaveChat(conversation); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save history: %v\n", err)
+	}
+}
@@ -2,15 +2,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/acazau/shell-ask-go/internal/config"
 	"github.com/acazau/shell-ask-go/internal/copilot"
+	"github.com/acazau/shell-ask-go/internal/httpclient"
 	"github.com/acazau/shell-ask-go/internal/models"
 	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/internal/schema"
 	"github.com/acazau/shell-ask-go/pkg/utils"
 	"github.com/acazau/shell-ask-go/pkg/version"
 	"github.com/spf13/cobra"
@@ -21,68 +26,205 @@ var rootCmd = &cobra.Command{
 	Short: "CLI tool for asking questions to AI models",
 	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if len(args) == 0 && !utils.IsPiped() {
-			return fmt.Errorf("please provide a prompt")
-		}
+		return runAsk(cmd, args, nil)
+	},
+}
 
-		// Get model flag and handle default case
-		modelFlag, _ := cmd.Flags().GetString("model")
-		if modelFlag == "" {
-			modelFlag = "gpt-4" // Default model from models.SelectModel
-		}
+// runAsk sends the prompt built from args, piped input and the context flags
+// to the selected model. command is the custom command being run, if any.
+func runAsk(cmd *cobra.Command, args []string, command *config.CustomCommand) error {
+	if len(args) == 0 && !utils.IsPiped() {
+		return fmt.Errorf("please provide a prompt")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	// A dry run records the requests instead of sending them
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	var capture *dryRunTransport
+	if dryRun {
+		capture = &dryRunTransport{}
+		cfg.HTTP.Transport = capture
+	} else if err := useCassettes(cmd, cfg); err != nil {
+		return err
+	}
+	httpClient, err := httpclient.New(cfg.HTTP)
+	if err != nil {
+		return fmt.Errorf("invalid http config: %w", err)
+	}
 
-		// Handle the prompt input
-		prompt := strings.Join(args, " ")
-		if pipeInput, err := utils.ReadPipe(); err != nil {
+	// Get model flag and handle default case
+	modelFlag, _ := cmd.Flags().GetString("model")
+	if modelFlag == "" {
+		modelFlag = cfg.DefaultModel
+	}
+	if modelFlag == "" {
+		modelFlag = "gpt-4" // Default model from models.SelectModel
+	}
+
+	// Handle the prompt input
+	prompt := strings.Join(args, " ")
+	pipeInput, err := utils.ReadPipe()
+	if err != nil {
+		return err
+	}
+	if pipeInput != "" {
+		prompt = fmt.Sprintf("%s\nInput:\n%s", prompt, pipeInput)
+	}
+
+	// Get command-only flag and append instruction if needed
+	commandOnly, _ := cmd.Flags().GetBool("command")
+	if commandOnly {
+		prompt += "\nReturn the command only without any other text."
+	}
+
+	// Ask for JSON matching --type
+	var shape map[string]interface{}
+	if typeFlag, _ := cmd.Flags().GetString("type"); typeFlag != "" {
+		shape, err = schema.Parse(typeFlag)
+		if err != nil {
 			return err
-		} else if pipeInput != "" {
-			prompt = fmt.Sprintf("%s\nInput:\n%s", prompt, pipeInput)
 		}
+		prompt += "\n" + schema.Instruction(shape)
+	}
 
-		// Get command-only flag and append instruction if needed
-		commandOnly, _ := cmd.Flags().GetBool("command")
-		if commandOnly {
-			prompt += "\nReturn the command only without any other text."
-		}
+	// Get breakdown flag and append instruction if needed
+	breakdown, _ := cmd.Flags().GetBool("breakdown")
+	if breakdown {
+		prompt += "\nProvide a detailed breakdown of what the command does."
+	}
 
-		// Get breakdown flag and append instruction if needed
-		breakdown, _ := cmd.Flags().GetBool("breakdown")
-		if breakdown {
-			prompt += "\nProvide a detailed breakdown of what the command does."
+	// Handle files context
+	files, _ := cmd.Flags().GetString("files")
+	if files != "" {
+		fileContent, err := utils.ReadFiles(strings.Split(files, ","))
+		if err != nil {
+			return fmt.Errorf("failed to read files: %w", err)
 		}
+		prompt = fmt.Sprintf("Files content:\n%s\n\nPrompt: %s", fileContent, prompt)
+	}
 
-		// Handle files context
-		files, _ := cmd.Flags().GetString("files")
-		if files != "" {
-			fileContent, err := utils.ReadFiles(strings.Split(files, ","))
-			if err != nil {
-				return fmt.Errorf("failed to read files: %w", err)
-			}
-			prompt = fmt.Sprintf("Files content:\n%s\n\nPrompt: %s", fileContent, prompt)
+	// Handle URL context
+	urls, _ := cmd.Flags().GetStringSlice("url")
+	if len(urls) > 0 && dryRun {
+		prompt = fmt.Sprintf("URL content:\n%s\n\nPrompt: %s", notFetched(urls), prompt)
+	} else if len(urls) > 0 {
+		urlContent, err := utils.FetchURLs(httpClient, urls)
+		if err != nil {
+			return fmt.Errorf("failed to fetch URLs: %w", err)
 		}
+		prompt = fmt.Sprintf("URL content:\n%s\n\nPrompt: %s", urlContent, prompt)
+	}
 
-		// Handle URL context
-		urls, _ := cmd.Flags().GetStringSlice("url")
-		if len(urls) > 0 {
-			urlContent, err := utils.FetchURLs(urls)
-			if err != nil {
-				return fmt.Errorf("failed to fetch URLs: %w", err)
-			}
-			prompt = fmt.Sprintf("URL content:\n%s\n\nPrompt: %s", urlContent, prompt)
+	// Handle image attachments
+	imageSources, _ := cmd.Flags().GetStringArray("image")
+	var imageURLs []string
+	if dryRun {
+		imageSources, imageURLs = splitURLs(imageSources)
+	}
+	images, err := utils.ReadImages(httpClient, imageSources)
+	if err != nil {
+		return err
+	}
+
+	// Get streaming flag
+	noStream, _ := cmd.Flags().GetBool("no-stream")
+
+	// Initialize provider
+	if cmd.Flags().Changed("retries") {
+		cfg.Retries, _ = cmd.Flags().GetInt("retries")
+	}
+
+	system, err := systemPrompt(cmd, cfg, command)
+	if err != nil {
+		return err
+	}
+
+	// Let several answers agree on one, by majority or by a judge model
+	compare, _ := cmd.Flags().GetStringSlice("compare")
+	consensus, _ := cmd.Flags().GetInt("consensus")
+	judge, _ := cmd.Flags().GetString("judge")
+	if cmd.Flags().Changed("consensus") && consensus < 2 {
+		return fmt.Errorf("--consensus needs at least 2 answers")
+	}
+	if consensus > 0 || judge != "" {
+		if judge == "" && !commandOnly {
+			// Only commands can be compared by a vote
+			judge = modelFlag
 		}
+		req := providers.NewRequest(prompt, false)
+		req.Messages[0].Images = images
+		req.System = system
+		return runConsensus(cmd, cfg, consensusPanel(modelFlag, compare, consensus), judge, req, pipeInput != "" || files != "")
+	}
 
-		// Get streaming flag
-		noStream, _ := cmd.Flags().GetBool("no-stream")
+	// Send the same request to several models at once
+	if len(compare) > 0 {
+		req := providers.NewRequest(prompt, !noStream)
+		req.Messages[0].Images = images
+		req.System = system
+		return runCompare(cmd, cfg, compare, req, pipeInput != "" || files != "")
+	}
 
-		// Initialize provider
-		provider, err := providers.InitializeProvider(modelFlag)
-		if err != nil {
-			return fmt.Errorf("failed to initialize provider: %w", err)
+	provider, err := providers.InitializeProviderWithConfig(cfg, modelFlag)
+	if err != nil {
+		return fmt.Errorf("failed to initialize provider: %w", err)
+	}
+
+	pricing := modelPricing(cfg, modelFlag)
+	// A dry run costs nothing, so it neither asks to confirm nor checks the
+	// budgets
+	if !dryRun && (pipeInput != "" || files != "") {
+		if err := confirmCost(cmd, cfg, pricing, system+prompt); err != nil {
+			return err
 		}
+	}
 
-		// Process the request
-		return providers.ProcessRequest(cmd.Context(), provider, prompt, !noStream)
-	},
+	spend, err := newSpendTracker(cmd, cfg, provider, modelFlag, pricing)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := spend.check(cmd, system+prompt); err != nil {
+			return err
+		}
+	}
+
+	req := providers.NewRequest(prompt, !noStream)
+	req.Messages[0].Images = images
+	req.System = system
+	req.Options = generationOptions(cmd, cfg, modelFlag)
+	req.ShowUsage, _ = cmd.Flags().GetBool("usage")
+	if _, ok := cfg.FallbackChain(modelFlag); !ok {
+		// The price of a chain depends on the model that answers
+		req.Pricing = pricing
+	}
+	req.OnUsage = spend.record
+	req.BeforeAttempt = spend.checkAttempt
+
+	agentMode, _ := cmd.Flags().GetBool("agent")
+	if dryRun {
+		return runDryRun(cmd.Context(), cmd.OutOrStdout(), cfg, provider, modelFlag, req, dryRunMode{
+			capture:   capture,
+			shape:     shape,
+			agent:     agentMode,
+			imageURLs: imageURLs,
+		})
+	}
+	if agentMode {
+		return runAgent(cmd.Context(), provider, req)
+	}
+	if shape != nil {
+		return runStructured(cmd.Context(), provider, req, shape)
+	}
+
+	// Process the request, keeping the answer if it is interrupted
+	req.OnAnswer = func(answer string, err error) {
+		saveHistory(modelFlag, req, answer, err)
+	}
+	return providers.ProcessRequest(cmd.Context(), provider, req)
 }
 
 func init() {
@@ -91,37 +233,201 @@ func init() {
 	rootCmd.PersistentFlags().BoolP("command", "c", false, "Ask LLM to return a command only")
 	rootCmd.PersistentFlags().BoolP("breakdown", "b", false, "Ask LLM to return a command and the breakdown")
 	rootCmd.PersistentFlags().String("files", "", "Adding files to model context")
-	rootCmd.PersistentFlags().StringP("type", "t", "", "Define the shape of the response")
+	rootCmd.PersistentFlags().StringArray("image", nil, "Attach an image file or URL for vision models (repeatable)")
+	rootCmd.PersistentFlags().StringP("type", "t", "", "Answer with JSON of this shape: a TypeScript-like type, a JSON Schema or @file")
 	rootCmd.PersistentFlags().StringSliceP("url", "u", []string{}, "Fetch URL content as context")
 	rootCmd.PersistentFlags().BoolP("search", "s", false, "Enable web search")
 	rootCmd.PersistentFlags().Bool("no-stream", true, "Disable streaming output")
 	rootCmd.PersistentFlags().BoolP("reply", "r", false, "Reply to previous conversation")
+	rootCmd.PersistentFlags().Float64("temperature", 0, "Sampling temperature")
+	rootCmd.PersistentFlags().Int("max-tokens", 0, "Maximum number of tokens to generate")
+	rootCmd.PersistentFlags().Float64("top-p", 0, "Nucleus sampling probability mass")
+	rootCmd.PersistentFlags().StringArray("stop", nil, "Stop generating at this sequence (repeatable)")
+	rootCmd.PersistentFlags().Int("seed", 0, "Seed for reproducible sampling, where supported")
+	rootCmd.PersistentFlags().String("system", "", "System prompt to send with the request")
+	rootCmd.PersistentFlags().String("system-file", "", "Read the system prompt from a file")
+	rootCmd.MarkFlagsMutuallyExclusive("system", "system-file")
+	rootCmd.PersistentFlags().Bool("usage", false, "Print token usage to stderr after the answer")
+	rootCmd.PersistentFlags().BoolP("yes", "y", false, "Send large inputs without asking to confirm the cost")
+	rootCmd.PersistentFlags().Bool("over-budget", false, "Send the request even if it exceeds a spend budget")
+	rootCmd.PersistentFlags().String("profile", "", "Profile to record the spend for and check its budget")
+	rootCmd.PersistentFlags().Int("retries", config.DefaultRetries, "Retries for rate-limited or failed requests")
+	rootCmd.PersistentFlags().Bool("agent", false, "Let the model run shell commands and read files, each call approved by you")
+	rootCmd.MarkFlagsMutuallyExclusive("agent", "type")
+	rootCmd.PersistentFlags().Bool("dry-run", false, "Print the request that would be sent, without sending anything")
+	rootCmd.PersistentFlags().String("record", "", "Record the HTTP exchanges into cassettes in this directory")
+	rootCmd.PersistentFlags().String("replay", "", "Answer from the cassettes in this directory instead of the network")
+	rootCmd.MarkFlagsMutuallyExclusive("record", "replay")
+	rootCmd.MarkFlagsMutuallyExclusive("dry-run", "record")
+	rootCmd.MarkFlagsMutuallyExclusive("dry-run", "replay")
+	rootCmd.PersistentFlags().StringSlice("compare", nil, "Send the prompt to each of these models at once and compare the answers")
+	for _, flag := range []string{"model", "agent", "type", "dry-run"} {
+		rootCmd.MarkFlagsMutuallyExclusive("compare", flag)
+	}
+	rootCmd.PersistentFlags().Int("consensus", 0, "Let this many answers agree on one, by majority for commands (-c) or by a judge")
+	rootCmd.PersistentFlags().String("judge", "", "Model selecting or merging the answers of --consensus or --compare")
+	for _, flag := range []string{"agent", "type", "dry-run"} {
+		rootCmd.MarkFlagsMutuallyExclusive("consensus", flag)
+		rootCmd.MarkFlagsMutuallyExclusive("judge", flag)
+	}
 
 	// Add built-in commands
 	addBuiltinCommands()
 }
 
+// systemPrompt returns the system prompt given with --system or
+// --system-file, falling back to the custom command's and then to the
+// configured default
+func systemPrompt(cmd *cobra.Command, cfg *config.Config, command *config.CustomCommand) (string, error) {
+	if system, _ := cmd.Flags().GetString("system"); system != "" {
+		return system, nil
+	}
+	if path, _ := cmd.Flags().GetString("system-file"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read system prompt file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if command != nil && command.System != "" {
+		return command.System, nil
+	}
+	return cfg.SystemPrompt, nil
+}
+
+// generationOptions merges the configured generation parameters for the model
+// with the ones given on the command line
+func generationOptions(cmd *cobra.Command, cfg *config.Config, modelID string) config.GenerationOptions {
+	var flags config.GenerationOptions
+	if cmd.Flags().Changed("temperature") {
+		temperature, _ := cmd.Flags().GetFloat64("temperature")
+		flags.Temperature = &temperature
+	}
+	if cmd.Flags().Changed("max-tokens") {
+		maxTokens, _ := cmd.Flags().GetInt("max-tokens")
+		flags.MaxTokens = &maxTokens
+	}
+	if cmd.Flags().Changed("top-p") {
+		topP, _ := cmd.Flags().GetFloat64("top-p")
+		flags.TopP = &topP
+	}
+	if cmd.Flags().Changed("stop") {
+		flags.Stop, _ = cmd.Flags().GetStringArray("stop")
+	}
+	if cmd.Flags().Changed("seed") {
+		seed, _ := cmd.Flags().GetInt("seed")
+		flags.Seed = &seed
+	}
+	return cfg.GenerationOptionsFor(modelID).Merge(flags)
+}
+
+// modelPricing returns the configured price of a model, falling back to the
+// built-in one. It returns nil when the price is unknown.
+func modelPricing(cfg *config.Config, modelID string) *config.Pricing {
+	// A fallback chain is priced by its first model until one answered
+	if chain, ok := cfg.FallbackChain(modelID); ok && len(chain) > 0 {
+		modelID = chain[0]
+	}
+	if pricing, ok := cfg.PricingFor(modelID); ok {
+		return &pricing
+	}
+	if input, output, ok := models.GetPricing(modelID); ok {
+		return &config.Pricing{Input: input, Output: output}
+	}
+	return nil
+}
+
+// confirmCost asks on the terminal before sending a prompt whose estimated
+// input cost exceeds the configured threshold
+func confirmCost(cmd *cobra.Command, cfg *config.Config, pricing *config.Pricing, prompt string) error {
+	if pricing == nil || cfg.ConfirmCost <= 0 {
+		return nil
+	}
+	if yes, _ := cmd.Flags().GetBool("yes"); yes {
+		return nil
+	}
+
+	tokens := models.EstimateTokens(prompt)
+	cost := pricing.Cost(tokens, 0)
+	if cost <= cfg.ConfirmCost {
+		return nil
+	}
+
+	question := fmt.Sprintf("The input is about %d tokens, an estimated $%.4f before the answer. Send it?", tokens, cost)
+	ok, err := utils.AskYesNoTTY(question)
+	if err != nil {
+		return fmt.Errorf("estimated input cost $%.4f exceeds $%.2f, use --yes to send it anyway", cost, cfg.ConfirmCost)
+	}
+	if !ok {
+		return fmt.Errorf("request cancelled")
+	}
+	return nil
+}
+
 func addBuiltinCommands() {
 	// List command
 	listCmd := &cobra.Command{
 		Use:   "list",
 		Short: "List available models",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
 			includeOllama, _ := cmd.Flags().GetBool("include-ollama")
-			models := models.GetAllModels(includeOllama)
+			allModels := models.GetAllModels(includeOllama)
 
-			if len(models) == 0 {
+			if len(allModels) == 0 && len(cfg.Endpoints) == 0 && len(cfg.Fallbacks) == 0 {
 				fmt.Fprintln(cmd.OutOrStdout(), "No models available.")
 				return nil
 			}
 
+			// Group the models by the provider that serves them
+			byProvider := make(map[string][]models.ModelInfo)
+			for _, model := range allModels {
+				if registration, ok := providers.Infer(model.ID); ok {
+					byProvider[registration.Name] = append(byProvider[registration.Name], model)
+				}
+			}
+
 			fmt.Fprintln(cmd.OutOrStdout(), "Available models:")
-			for _, model := range models {
-				desc := model.Description
-				if desc == "" {
-					desc = model.Family
+			for _, registration := range providers.Registered() {
+				header := registration.Name
+				if cred := registration.MissingCredential(cfg); cred != nil {
+					header += fmt.Sprintf(" (%s to enable)", cred.Hint())
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s:\n", header)
+				for _, model := range byProvider[registration.Name] {
+					desc := model.Description
+					if desc == "" {
+						desc = model.Family
+					}
+					if desc != "" {
+						fmt.Fprintf(cmd.OutOrStdout(), "  - %s (%s)\n", model.ID, desc)
+					} else {
+						fmt.Fprintf(cmd.OutOrStdout(), "  - %s\n", model.ID)
+					}
 				}
-				fmt.Fprintf(cmd.OutOrStdout(), "- %s (%s)\n", model.ID, desc)
+			}
+
+			endpointNames := make([]string, 0, len(cfg.Endpoints))
+			for name := range cfg.Endpoints {
+				endpointNames = append(endpointNames, name)
+			}
+			sort.Strings(endpointNames)
+			for _, name := range endpointNames {
+				endpoint := cfg.Endpoints[name]
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: OpenAI-compatible endpoint at %s, use -m %s/<model>\n", name, endpoint.BaseURL, name)
+			}
+
+			chainNames := make([]string, 0, len(cfg.Fallbacks))
+			for name := range cfg.Fallbacks {
+				chainNames = append(chainNames, name)
+			}
+			sort.Strings(chainNames)
+			for _, name := range chainNames {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: fallback chain %s, use -m %s\n", name, strings.Join(cfg.Fallbacks[name], " -> "), name)
 			}
 
 			return nil
@@ -148,28 +454,75 @@ func addBuiltinCommands() {
 				return fmt.Errorf("this command requires git diff input")
 			}
 
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			// A dry run records the request instead of sending it
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			var capture *dryRunTransport
+			if dryRun {
+				capture = &dryRunTransport{}
+				cfg.HTTP.Transport = capture
+			} else if err := useCassettes(cmd, cfg); err != nil {
+				return err
+			}
+
 			modelFlag, _ := cmd.Flags().GetString("model")
 			if modelFlag == "" {
 				modelFlag = models.GetCheapModel("gpt-4") // Use cheaper model for commit messages
 			}
 
-			provider, err := providers.InitializeProvider(modelFlag)
+			if cmd.Flags().Changed("retries") {
+				cfg.Retries, _ = cmd.Flags().GetInt("retries")
+			}
+			provider, err := providers.InitializeProviderWithConfig(cfg, modelFlag)
 			if err != nil {
 				return fmt.Errorf("failed to initialize provider: %w", err)
 			}
 
+			pricing := modelPricing(cfg, modelFlag)
+			spend, err := newSpendTracker(cmd, cfg, provider, modelFlag, pricing)
+			if err != nil {
+				return err
+			}
+
 			prompt := "Generate a git commit message based on the following diff:"
-			return providers.ProcessRequest(cmd.Context(), provider, prompt, true)
+			if !dryRun {
+				if err := spend.check(cmd, prompt); err != nil {
+					return err
+				}
+			}
+
+			req := providers.NewRequest(prompt, true)
+			req.Options = generationOptions(cmd, cfg, modelFlag)
+			req.ShowUsage, _ = cmd.Flags().GetBool("usage")
+			if _, ok := cfg.FallbackChain(modelFlag); !ok {
+				req.Pricing = pricing
+			}
+			req.OnUsage = spend.record
+			req.BeforeAttempt = spend.checkAttempt
+			if dryRun {
+				return runDryRun(cmd.Context(), cmd.OutOrStdout(), cfg, provider, modelFlag, req, dryRunMode{capture: capture})
+			}
+			return providers.ProcessRequest(cmd.Context(), provider, req)
 		},
 	}
 	rootCmd.AddCommand(cmCmd)
 
+	// Spend report command
+	rootCmd.AddCommand(newUsageCmd())
+
 	// Copilot login command
 	copilotLoginCmd := &cobra.Command{
 		Use:   "copilot-login",
 		Short: "Login to GitHub Copilot",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			copilotClient := copilot.New(config.GetConfigDir())
+			copilotClient, err := newCopilotClient()
+			if err != nil {
+				return err
+			}
 
 			deviceCode, err := copilotClient.RequestDeviceCode()
 			if err != nil {
@@ -219,7 +572,10 @@ func addBuiltinCommands() {
 		Use:   "copilot-logout",
 		Short: "Logout from GitHub Copilot",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			copilotClient := copilot.New(config.GetConfigDir())
+			copilotClient, err := newCopilotClient()
+			if err != nil {
+				return err
+			}
 			if err := copilotClient.RemoveAuthToken(); err != nil {
 				return fmt.Errorf("failed to remove auth token: %w", err)
 			}
@@ -230,9 +586,74 @@ func addBuiltinCommands() {
 	rootCmd.AddCommand(copilotLogoutCmd)
 }
 
+// newCopilotClient returns a Copilot client using the configured transport
+func newCopilotClient() (*copilot.Client, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	client, err := httpclient.New(cfg.HTTP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http config: %w", err)
+	}
+	return copilot.New(config.GetConfigDir(), client), nil
+}
+
+// addCustomCommands registers the custom commands defined in the config.
+// Commands named like a built-in command are skipped.
+func addCustomCommands(cfg *config.Config) {
+	existing := make(map[string]bool)
+	for _, cmd := range rootCmd.Commands() {
+		existing[cmd.Name()] = true
+	}
+
+	for _, command := range cfg.Commands {
+		c := command // Create a new variable to avoid closure problems
+		if c.Name == "" || existing[c.Name] {
+			continue
+		}
+		rootCmd.AddCommand(&cobra.Command{
+			Use:     c.Name,
+			Short:   c.Description,
+			Example: c.Example,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if c.RequireStdin && !utils.IsPiped() {
+					return fmt.Errorf("this command requires piped input")
+				}
+				return runAsk(cmd, append([]string{c.Prompt}, args...), &c)
+			},
+		})
+	}
+}
+
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	// A broken config is reported when a command loads it
+	if cfg, err := config.Load(); err == nil {
+		addCustomCommands(cfg)
+	}
+
+	ctx, stop := interruptContext()
+	// An interruption was already reported, so cobra prints neither the
+	// error nor the usage
+	cobra.OnFinalize(func() {
+		if ctx.Err() != nil {
+			rootCmd.SilenceErrors = true
+			rootCmd.SilenceUsage = true
+		}
+	})
+	err := rootCmd.ExecuteContext(ctx)
+	interrupted := ctx.Err() != nil
+	stop()
+	if err != nil && interrupted && errors.Is(err, context.Canceled) {
+		// The partial answer is already printed
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		var providerErr *providers.Error
+		if errors.As(err, &providerErr) && providerErr.Hint != "" {
+			fmt.Fprintf(os.Stderr, "Hint: %s\n", providerErr.Hint)
+		}
+		os.Exit(providers.ExitCode(err))
 	}
 }
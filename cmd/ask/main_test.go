@@ -2,11 +2,27 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/acazau/shell-ask-go/internal/cli"
 	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/copilot"
+	"github.com/acazau/shell-ask-go/internal/ledger"
+	"github.com/acazau/shell-ask-go/internal/models"
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/internal/schema"
+	"github.com/acazau/shell-ask-go/pkg/chat"
 	"github.com/spf13/cobra"
 )
 
@@ -113,3 +129,584 @@ func TestListCommand(t *testing.T) {
 		t.Errorf("Expected output:\n%s\nGot:\n%s", expected, actual)
 	}
 }
+
+func TestSystemPrompt(t *testing.T) {
+	newCmd := func() *cobra.Command {
+		cmd := &cobra.Command{Use: "ask"}
+		cmd.Flags().String("system", "", "")
+		cmd.Flags().String("system-file", "", "")
+		return cmd
+	}
+	cfg := &config.Config{SystemPrompt: "global prompt"}
+	command := &config.CustomCommand{Name: "explain", System: "command prompt"}
+
+	if got, _ := systemPrompt(newCmd(), cfg, nil); got != "global prompt" {
+		t.Errorf("expected configured system prompt, got %q", got)
+	}
+	if got, _ := systemPrompt(newCmd(), cfg, command); got != "command prompt" {
+		t.Errorf("expected custom command system prompt, got %q", got)
+	}
+
+	cmd := newCmd()
+	cmd.Flags().Set("system", "flag prompt")
+	if got, _ := systemPrompt(cmd, cfg, command); got != "flag prompt" {
+		t.Errorf("expected --system to take precedence, got %q", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "system.txt")
+	if err := os.WriteFile(path, []byte("file prompt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd = newCmd()
+	cmd.Flags().Set("system-file", path)
+	if got, _ := systemPrompt(cmd, cfg, command); got != "file prompt" {
+		t.Errorf("expected --system-file content, got %q", got)
+	}
+}
+
+func TestModelPricing(t *testing.T) {
+	cfg := &config.Config{Pricing: map[string]config.Pricing{"gpt-4": {Input: 1, Output: 2}}}
+
+	if pricing := modelPricing(cfg, "gpt-4"); pricing == nil || pricing.Input != 1 {
+		t.Errorf("expected configured pricing to override the built-in one, got %+v", pricing)
+	}
+	if pricing := modelPricing(cfg, "claude-3-opus"); pricing == nil || pricing.Output != 75 {
+		t.Errorf("expected built-in pricing, got %+v", pricing)
+	}
+	for _, id := range []string{"gpt-4o", models.SelectModel("")} {
+		if pricing := modelPricing(cfg, id); pricing == nil || pricing.Input == 0 {
+			t.Errorf("expected built-in pricing for the default model %s, got %+v", id, pricing)
+		}
+	}
+	if pricing := modelPricing(cfg, "qwen2.5:7b"); pricing != nil {
+		t.Errorf("expected no pricing for an unknown model, got %+v", pricing)
+	}
+}
+
+func TestConfirmCostUnderThreshold(t *testing.T) {
+	cmd := &cobra.Command{Use: "ask"}
+	cmd.Flags().Bool("yes", false, "")
+	cfg := &config.Config{ConfirmCost: 0.10}
+	pricing := &config.Pricing{Input: 1, Output: 2}
+
+	if err := confirmCost(cmd, cfg, pricing, "short prompt"); err != nil {
+		t.Errorf("expected a cheap prompt to pass, got %v", err)
+	}
+
+	// About 250k tokens at $1 per million is above the threshold
+	cmd.Flags().Set("yes", "true")
+	if err := confirmCost(cmd, cfg, pricing, strings.Repeat("word ", 200000)); err != nil {
+		t.Errorf("expected --yes to skip the confirmation, got %v", err)
+	}
+}
+
+func TestWriteUsageReport(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	entries := []ledger.Entry{
+		{Time: now, Provider: "openai", Model: "gpt-4", Command: "ask", Cost: 0.5},
+		{Time: now.AddDate(0, 0, -1), Provider: "anthropic", Model: "claude-3-haiku", Command: "cm", Cost: 0.01},
+		{Time: now.AddDate(0, 0, -1), Provider: "openai", Model: "gpt-4", Command: "ask", Cost: 0.25},
+		{Time: now.AddDate(0, 0, -60), Provider: "openai", Model: "gpt-4", Command: "ask", Cost: 9},
+	}
+	budgets := config.Budgets{Providers: map[string]config.Budget{"openai": {Daily: 2}}}
+
+	var out bytes.Buffer
+	writeUsageReport(&out, entries, budgets, 30, now)
+	report := out.String()
+
+	for _, want := range []string{
+		"Spend over the last 30 days: $0.7600 in 3 requests",
+		"2026-10-16  $0.2600  2 requests",
+		"gpt-4           $0.7500  2 requests",
+		"cm   $0.0100  1 requests",
+		"provider openai: $0.5000 today of $2.00, $0.7500 this month",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("expected report to contain %q, got:\n%s", want, report)
+		}
+	}
+}
+
+// scriptedProvider answers with the answers in order
+type scriptedProvider struct {
+	answers  []string
+	requests int
+}
+
+func (p *scriptedProvider) Complete(ctx context.Context, req *providers.Request) (io.ReadCloser, error) {
+	answer := p.answers[p.requests]
+	p.requests++
+	return io.NopCloser(strings.NewReader(answer)), nil
+}
+
+func (p *scriptedProvider) Name() string {
+	return "scripted"
+}
+
+func TestRunStructuredRetriesInvalidJSON(t *testing.T) {
+	shape, _ := schema.Parse("{name: string}")
+	provider := &scriptedProvider{answers: []string{"not json", `{"name": 1}`, "```json\n{\"name\": \"ask\"}\n```"}}
+
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := runStructured(context.Background(), provider, providers.NewRequest("name?", true), shape)
+	w.Close()
+	os.Stdout = stdout
+	out, _ := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider.requests != 3 {
+		t.Errorf("expected 3 attempts, got %d", provider.requests)
+	}
+	if want := "{\n  \"name\": \"ask\"\n}\n"; string(out) != want {
+		t.Errorf("expected only the JSON %q, got %q", want, out)
+	}
+
+	provider = &scriptedProvider{answers: []string{"no", "no", "no"}}
+	if err := runStructured(context.Background(), provider, providers.NewRequest("name?", true), shape); err == nil {
+		t.Error("expected an error once the retries are exhausted")
+	}
+}
+
+// isolateHome points the home, config and cache directories at a temporary
+// directory, so command tests never read or write the files of the user.
+// It returns the temporary home.
+func isolateHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	return home
+}
+
+func TestRunDryRun(t *testing.T) {
+	capture := &dryRunTransport{}
+	cfg := &config.Config{OpenAIKey: "sk-secret"}
+	cfg.HTTP.Transport = capture
+	provider, err := providers.InitializeProviderWithConfig(cfg, "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := providers.NewRequest("list files", true)
+	req.System = "Be brief."
+	temperature := 0.2
+	req.Options.Temperature = &temperature
+
+	var out bytes.Buffer
+	if err := runDryRun(context.Background(), &out, cfg, provider, "gpt-4o", req, dryRunMode{capture: capture}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(capture.captured()) != 1 {
+		t.Fatalf("expected one captured request, got %d", len(capture.captured()))
+	}
+
+	printed := out.String()
+	for _, want := range []string{
+		"Provider: openai",
+		"Model: gpt-4o",
+		`Parameters: {"temperature":0.2}`,
+		"[system]\nBe brief.",
+		"[user]\nlist files",
+		"POST https://api.openai.com/v1/chat/completions",
+		"Authorization: [redacted]",
+		`"temperature": 0.2`,
+		"Estimated input tokens:",
+	} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("expected %q in the dry run output:\n%s", want, printed)
+		}
+	}
+	if strings.Contains(printed, "sk-secret") {
+		t.Error("expected the API key to be redacted")
+	}
+}
+
+func TestRunDryRunCopilot(t *testing.T) {
+	isolateHome(t)
+	if err := copilot.New(config.GetConfigDir(), nil).SaveAuthToken("gho_secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	capture := &dryRunTransport{}
+	cfg := &config.Config{}
+	cfg.HTTP.Transport = capture
+	provider, err := providers.InitializeProviderWithConfig(cfg, "copilot-gpt-4o")
+	if err != nil {
+		t.Fatalf("expected the token exchange to be answered in a dry run, got %v", err)
+	}
+
+	var out bytes.Buffer
+	req := providers.NewRequest("list files", false)
+	if err := runDryRun(context.Background(), &out, cfg, provider, "copilot-gpt-4o", req, dryRunMode{capture: capture}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests := capture.captured(); len(requests) != 1 || requests[0].URL != "https://api.githubcopilot.com/chat/completions" {
+		t.Errorf("expected only the chat request to be captured, got %+v", requests)
+	}
+	if printed := out.String(); !strings.Contains(printed, "Authorization: [redacted]") || strings.Contains(printed, "gho_secret") {
+		t.Errorf("expected the token to be redacted:\n%s", printed)
+	}
+}
+
+func TestCommitMessageDryRun(t *testing.T) {
+	home := isolateHome(t)
+
+	var sent int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { sent++ }))
+	defer server.Close()
+	dir := filepath.Join(home, ".config", "shell-ask")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := fmt.Sprintf(`{"openai_api_key": "sk-secret", "openai_api_url": %q}`, server.URL)
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := os.Stdin
+	r, w, _ := os.Pipe()
+	w.WriteString("diff --git a/main.go b/main.go\n")
+	w.Close()
+	os.Stdin = r
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	defer func() {
+		os.Stdin = stdin
+		rootCmd.SetOut(nil)
+		rootCmd.PersistentFlags().Set("dry-run", "false")
+		rootCmd.PersistentFlags().Set("model", "")
+	}()
+
+	rootCmd.SetArgs([]string{"cm", "--dry-run", "-m", "gpt-4o"})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sent != 0 {
+		t.Errorf("expected no request to be sent in a dry run, got %d", sent)
+	}
+	if printed := out.String(); !strings.Contains(printed, "POST "+server.URL+"/chat/completions") || strings.Contains(printed, "sk-secret") {
+		t.Errorf("expected the redacted commit message request:\n%s", printed)
+	}
+}
+
+func TestAskWithMockProvider(t *testing.T) {
+	isolateHome(t)
+
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	rootCmd.SetArgs([]string{"-m", "mock/echo", "--no-stream=false", "hello from the test"})
+	err := rootCmd.Execute()
+	w.Close()
+	os.Stdout = stdout
+	out, _ := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(out), "hello from the test") {
+		t.Errorf("expected the echoed prompt, got %q", out)
+	}
+}
+
+func TestRunCompare(t *testing.T) {
+	isolateHome(t)
+
+	cmd := &cobra.Command{Use: "ask"}
+	cmd.Flags().Bool("over-budget", false, "")
+	cmd.Flags().String("profile", "", "")
+	cmd.SetContext(context.Background())
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+
+	models := []string{"mock/echo?latency=20ms", "mock/echo?error=rate_limited", "nope/model"}
+	err := runCompare(cmd, &config.Config{}, models, providers.NewRequest("compare me", true), false)
+	if err != nil {
+		t.Fatalf("expected one answer to be enough, got %v", err)
+	}
+
+	report := out.String()
+	for _, want := range []string{
+		"=== mock/echo?latency=20ms ===\ncompare me\n",
+		"=== mock/echo?error=rate_limited ===\nError: ",
+		"=== nope/model ===\nError: unsupported provider: nope",
+		"Model                         Latency",
+		"mock/echo?error=rate_limited  rate limited",
+		"nope/model                    failed",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, report)
+		}
+	}
+
+	out.Reset()
+	err = runCompare(cmd, &config.Config{}, models[1:], providers.NewRequest("compare me", true), false)
+	if err == nil || !strings.Contains(err.Error(), "every model failed") {
+		t.Errorf("expected an error when every model fails, got %v", err)
+	}
+}
+
+func TestCompareChecksBudgetsTogether(t *testing.T) {
+	isolateHome(t)
+	cmd := &cobra.Command{Use: "ask"}
+	cmd.Flags().Bool("over-budget", false, "")
+	cmd.Flags().String("profile", "", "")
+	cmd.SetContext(context.Background())
+	cmd.SetOut(io.Discard)
+
+	// Each run of the 100 token prompt is estimated at $1
+	cfg := &config.Config{
+		Pricing: map[string]config.Pricing{"mock/echo": {Input: 10000}},
+		Budgets: config.Budgets{Providers: map[string]config.Budget{"mock": {Daily: 2.5}}},
+	}
+	req := providers.NewRequest(strings.Repeat("x", 400), false)
+
+	if err := runCompare(cmd, cfg, []string{"mock/echo", "mock/echo"}, req, false); err != nil {
+		t.Fatalf("expected two runs to fit the budget, got %v", err)
+	}
+	var budgetErr *ledger.BudgetError
+	err := runCompare(cmd, cfg, []string{"mock/echo", "mock/echo", "mock/echo"}, req, false)
+	if !errors.As(err, &budgetErr) || budgetErr.Estimate != 3 {
+		t.Errorf("expected the three runs to exceed the budget together, got %v", err)
+	}
+}
+
+func TestCompareRecordsInterruptedUsage(t *testing.T) {
+	provider, err := providers.InitializeProviderWithConfig(&config.Config{}, "mock/echo?chunk_delay=50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &comparison{
+		model:    "mock/echo",
+		provider: provider,
+		req:      providers.NewRequest("one two three four five six seven eight", true),
+		output:   newLiveBuffer(),
+	}
+	var recorded *providers.Usage
+	run.req.OnUsage = func(usage providers.Usage) { recorded = &usage }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer time.AfterFunc(120*time.Millisecond, cancel).Stop()
+	cmd := &cobra.Command{Use: "ask"}
+	cmd.SetContext(ctx)
+	run.complete(cmd)
+
+	if !errors.Is(run.err, context.Canceled) {
+		t.Errorf("expected the cancellation, got %v", run.err)
+	}
+	if recorded == nil || recorded.InputTokens == 0 {
+		t.Errorf("expected the usage of the interrupted answer to be recorded, got %+v", recorded)
+	}
+}
+
+func TestCompareColumnWidth(t *testing.T) {
+	if width := compareColumnWidth(&bytes.Buffer{}, 2); width != 0 {
+		t.Errorf("expected sections for output that is not a terminal, got columns of %d", width)
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	if width := compareColumnWidth(devNull, 2); width != 0 {
+		t.Errorf("expected sections for a file that is not a terminal, got columns of %d", width)
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	got := wrapText("the quick brown fox\njumps", 9)
+	want := []string{"the quick", "brown fox", "jumps"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	got = wrapText("abcdefghij", 4)
+	want = []string{"abcd", "efgh", "ij"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected a long word to be cut, got %q", got)
+	}
+}
+
+func TestConsensusPanel(t *testing.T) {
+	tests := []struct {
+		model   string
+		compare []string
+		size    int
+		want    string
+	}{
+		{"gpt-4o", nil, 0, "gpt-4o,gpt-4o,gpt-4o"},
+		{"gpt-4o", nil, 2, "gpt-4o,gpt-4o"},
+		{"gpt-4o", []string{"a", "b"}, 0, "a,b"},
+		{"gpt-4o", []string{"a", "b"}, 3, "a,b,a"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(consensusPanel(tt.model, tt.compare, tt.size), ","); got != tt.want {
+			t.Errorf("consensusPanel(%q, %v, %d) = %q, want %q", tt.model, tt.compare, tt.size, got, tt.want)
+		}
+	}
+}
+
+// mockFixture writes a fixture for mock/fixture:path answering with content
+func mockFixture(t *testing.T, content string) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"responses": []map[string]string{{"content": content}},
+	})
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return "mock/fixture:" + path
+}
+
+func newConsensusCommand(t *testing.T) (*cobra.Command, *bytes.Buffer, *bytes.Buffer) {
+	isolateHome(t)
+
+	cmd := &cobra.Command{Use: "ask"}
+	cmd.Flags().Bool("over-budget", false, "")
+	cmd.Flags().String("profile", "", "")
+	cmd.Flags().Bool("usage", false, "")
+	cmd.SetContext(context.Background())
+	var out, stderr bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&stderr)
+	return cmd, &out, &stderr
+}
+
+func TestRunConsensusVote(t *testing.T) {
+	cmd, out, stderr := newConsensusCommand(t)
+	panel := []string{
+		mockFixture(t, "```bash\nls  -la\n```"),
+		mockFixture(t, "ls -a"),
+		mockFixture(t, "ls -la"),
+		"mock/echo?error=server",
+	}
+
+	if err := runConsensus(cmd, &config.Config{}, panel, "", providers.NewRequest("list files", false), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != "ls  -la\n" {
+		t.Errorf("expected the majority command, got %q", out.String())
+	}
+	for _, want := range []string{
+		"Warning: answer 4 (mock/echo?error=server) failed",
+		"Consensus: 2 of 3 answers agree. The others answered:\n  answer 2 (" + panel[1] + "): ls -a\n",
+	} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("expected stderr to contain %q, got:\n%s", want, stderr.String())
+		}
+	}
+}
+
+func TestRunConsensusJudge(t *testing.T) {
+	cmd, out, stderr := newConsensusCommand(t)
+	judge := mockFixture(t, `{"answer": "use ls -la", "agree": false, "disagreements": ["whether to show hidden files"]}`)
+
+	err := runConsensus(cmd, &config.Config{}, []string{"mock/echo", "mock/echo"}, judge, providers.NewRequest("list files", false), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != "use ls -la\n" {
+		t.Errorf("expected the answer of the judge, got %q", out.String())
+	}
+	want := "Judge " + judge + ": the answers disagree\n  - whether to show hidden files\n"
+	if stderr.String() != want {
+		t.Errorf("expected %q, got %q", want, stderr.String())
+	}
+}
+
+func TestRunConsensusChecksJudgeBudget(t *testing.T) {
+	cmd, _, _ := newConsensusCommand(t)
+	judge := mockFixture(t, `{"answer": "ls", "agree": true, "disagreements": []}`)
+	// The panel fits the budget, but not together with the judge
+	cfg := &config.Config{
+		Pricing: map[string]config.Pricing{
+			"mock/echo":            {Input: 10000},
+			strings.ToLower(judge): {Input: 10000},
+		},
+		Budgets: config.Budgets{Providers: map[string]config.Budget{"mock": {Daily: 2.5}}},
+	}
+
+	var budgetErr *ledger.BudgetError
+	err := runConsensus(cmd, cfg, []string{"mock/echo", "mock/echo"}, judge, providers.NewRequest(strings.Repeat("x", 400), false), false)
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected the panel and the judge to exceed the budget together, got %v", err)
+	}
+	book, _ := ledger.Default()
+	if entries, _ := book.Entries(); len(entries) != 0 {
+		t.Errorf("expected no answer to be paid for, got %+v", entries)
+	}
+}
+
+func TestSaveHistoryMarksInterruptedAnswer(t *testing.T) {
+	home := isolateHome(t)
+	path := filepath.Join(home, ".cache", "shell-ask", "chat.json")
+
+	req := providers.NewRequest("count to ten", true)
+	req.System = "be brief"
+	saveHistory("mock/echo", req, "one two", fmt.Errorf("failed to complete request: %w", context.Canceled))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved chat.Chat
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Interrupted || saved.Model != "mock/echo" || len(saved.Messages) != 3 || saved.Messages[2].Content != "one two" {
+		t.Errorf("expected the interrupted conversation, got %+v", saved)
+	}
+
+	// Failed and complete answers are not kept
+	saveHistory("mock/echo", req, "", errors.New("server error"))
+	saveHistory("mock/echo", providers.NewRequest("again", true), "done", nil)
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, data) {
+		t.Errorf("expected only the interrupted answer to be kept, got %s", after)
+	}
+}
+
+func TestSpendCheckPerFallbackModel(t *testing.T) {
+	isolateHome(t)
+
+	cfg := &config.Config{
+		Fallbacks: map[string][]string{"safe": {"mock/echo?latency=1ms", "mock/echo"}},
+		// Only the first model of the chain is over the daily budget
+		Pricing: map[string]config.Pricing{"mock/echo?latency=1ms": {Input: 1000000}},
+		Budgets: config.Budgets{Providers: map[string]config.Budget{"mock": {Daily: 1}}},
+	}
+	provider, err := providers.InitializeProviderWithConfig(cfg, "safe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &cobra.Command{Use: "ask"}
+	cmd.Flags().Bool("over-budget", false, "")
+	cmd.Flags().String("profile", "", "")
+	spend, err := newSpendTracker(cmd, cfg, provider, "safe", modelPricing(cfg, "safe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := spend.check(cmd, "hello budget"); err != nil {
+		t.Fatalf("expected the chain to be checked per model, got %v", err)
+	}
+	req := providers.NewRequest("hello budget", false)
+	req.BeforeAttempt = spend.checkAttempt
+	if _, _, err := completeText(context.Background(), provider, req); err != nil {
+		t.Fatalf("expected the second model to answer, got %v", err)
+	}
+	if answered := provider.(*providers.FallbackProvider).Answered(); answered != "mock/echo" {
+		t.Errorf("expected the model over budget to be skipped, got %q", answered)
+	}
+
+	// Neither model fits a spent budget
+	cfg.Pricing["mock/echo"] = config.Pricing{Input: 1000000}
+	var budgetErr *ledger.BudgetError
+	if _, _, err := completeText(context.Background(), provider, req); !errors.As(err, &budgetErr) {
+		t.Errorf("expected a budget error, got %v", err)
+	}
+}
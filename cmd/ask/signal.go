@@ -0,0 +1,46 @@
+// cmd/ask/signal.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitInterrupted is the exit status after Ctrl-C, as for a shell command
+// killed by SIGINT
+const exitInterrupted = 130
+
+// interruptContext returns a context cancelled by the first SIGINT or
+// SIGTERM, so the request in flight stops and what was answered so far is
+// kept. A second signal exits at once.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		cancel()
+		fmt.Fprintln(os.Stderr, "\nInterrupted, press Ctrl-C again to exit at once")
+
+		select {
+		case <-signals:
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,87 @@
+// cmd/ask/structured.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/internal/schema"
+	"github.com/acazau/shell-ask-go/pkg/chat"
+)
+
+// typeRetries is how often an answer not matching --type is sent back to the
+// model with the validation error
+const typeRetries = 2
+
+// runStructured asks for an answer matching the JSON Schema and prints only
+// the validated JSON, so it can be piped into tools such as jq
+func runStructured(ctx context.Context, provider providers.Provider, req *providers.Request, shape map[string]interface{}) error {
+	var total providers.Usage
+	// The spend is reported even if no answer was valid
+	defer func() { reportUsage(req, total) }()
+
+	value, err := completeStructured(ctx, provider, req, shape, &total)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, value, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// completeStructured returns the JSON of the first answer matching the JSON
+// Schema, sending invalid answers back to the model with the validation
+// error. The usage of every attempt is added to total.
+func completeStructured(ctx context.Context, provider providers.Provider, req *providers.Request, shape map[string]interface{}, total *providers.Usage) (json.RawMessage, error) {
+	req.Schema = shape
+	// The whole answer is needed before it can be validated
+	req.Stream = false
+
+	var lastErr error
+	for attempt := 0; attempt <= typeRetries; attempt++ {
+		answer, usage, err := completeText(ctx, provider, req)
+		total.Add(usage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to complete request: %w", providers.ClassifyError(provider.Name(), err))
+		}
+
+		value, err := schema.Extract(answer)
+		if err == nil {
+			err = schema.Validate(shape, value)
+		}
+		if err == nil {
+			return value, nil
+		}
+
+		lastErr = err
+		req.Messages = append(req.Messages,
+			chat.Message{Role: chat.RoleAssistant, Content: answer},
+			chat.Message{Role: chat.RoleUser, Content: fmt.Sprintf(
+				"That answer is invalid: %v. Respond again with only the JSON value matching the schema.", err)},
+		)
+	}
+	return nil, fmt.Errorf("the answer does not match the schema after %d attempts: %w", typeRetries+1, lastErr)
+}
+
+// completeText reads the whole answer of a request and its usage
+func completeText(ctx context.Context, provider providers.Provider, req *providers.Request) (string, providers.Usage, error) {
+	reader, err := provider.Complete(ctx, req)
+	if err != nil {
+		return "", providers.Usage{}, err
+	}
+	defer reader.Close()
+
+	answer, err := io.ReadAll(reader)
+	var usage providers.Usage
+	if reporter, ok := reader.(providers.UsageReporter); ok {
+		usage = reporter.Usage()
+	}
+	return string(answer), usage, err
+}
@@ -0,0 +1,322 @@
+// cmd/ask/usage.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/ledger"
+	"github.com/acazau/shell-ask-go/internal/models"
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+// spendTracker checks the spend budgets before a request and records its
+// cost in the ledger afterwards
+type spendTracker struct {
+	ledger   *ledger.Ledger
+	cfg      *config.Config
+	command  string
+	provider providers.Provider
+	model    string
+	profile  string
+	pricing  *config.Pricing
+
+	// checked is set by estimate, with the estimated input tokens and
+	// whether the budgets are overridden, for checkAttempt
+	checked    bool
+	tokens     int
+	overBudget bool
+	// others is the estimated cost of the requests sent together with this
+	// one, set by checkBudgets
+	others spendShare
+}
+
+// spendShare is an estimated cost per provider and in total
+type spendShare struct {
+	providers map[string]float64
+	total     float64
+}
+
+func newSpendTracker(cmd *cobra.Command, cfg *config.Config, provider providers.Provider, modelID string, pricing *config.Pricing) (*spendTracker, error) {
+	book, err := ledger.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	profile := cfg.Profile
+	if cmd.Flags().Changed("profile") {
+		profile, _ = cmd.Flags().GetString("profile")
+	}
+
+	return &spendTracker{
+		ledger:   book,
+		cfg:      cfg,
+		command:  cmd.Name(),
+		provider: provider,
+		model:    modelID,
+		profile:  profile,
+		pricing:  pricing,
+	}, nil
+}
+
+// check refuses the request when its estimated cost would exceed a budget,
+// unless --over-budget is given. The models of a fallback chain are checked
+// by checkAttempt before each is tried, as any of them may answer.
+func (s *spendTracker) check(cmd *cobra.Command, prompt string) error {
+	s.estimate(cmd, prompt)
+	return checkBudgets([]*spendTracker{s})
+}
+
+// estimate sets the input tokens of the request sending prompt, and whether
+// --over-budget overrides the budgets
+func (s *spendTracker) estimate(cmd *cobra.Command, prompt string) {
+	s.checked = true
+	s.tokens = models.EstimateTokens(prompt)
+	s.overBudget, _ = cmd.Flags().GetBool("over-budget")
+}
+
+// checkBudgets refuses requests sent together, such as the runs of
+// --compare, when their estimated costs added up would exceed a budget: per
+// provider for the provider budgets and all of them for the profile ones.
+// Every tracker must have been estimated. The models of fallback chains are
+// checked by checkAttempt, with the costs of the other requests added.
+func checkBudgets(spends []*spendTracker) error {
+	var all spendShare
+	all.providers = make(map[string]float64)
+	for _, s := range spends {
+		cost := s.cost(s.pricing)
+		all.total += cost
+		if !s.fallback() {
+			all.providers[s.provider.Name()] += cost
+		}
+	}
+
+	checked := make(map[string]bool)
+	for _, s := range spends {
+		cost := s.cost(s.pricing)
+		s.others = spendShare{providers: make(map[string]float64), total: all.total - cost}
+		for provider, total := range all.providers {
+			s.others.providers[provider] = total
+		}
+		if !s.fallback() {
+			s.others.providers[s.provider.Name()] -= cost
+		}
+	}
+	for _, s := range spends {
+		// Requests to the same provider have the same sums to check
+		if s.overBudget || s.fallback() || checked[s.provider.Name()] {
+			continue
+		}
+		checked[s.provider.Name()] = true
+		if err := s.checkModel(s.provider.Name(), s.pricing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAttempt checks the budgets of a model of a fallback chain before the
+// request is sent to it, as set in providers.Request.BeforeAttempt
+func (s *spendTracker) checkAttempt(modelID, provider string) error {
+	if !s.checked || s.overBudget {
+		return nil
+	}
+	return s.checkModel(provider, modelPricing(s.cfg, modelID))
+}
+
+// checkModel checks the budgets of provider and of the profile for the
+// request sent at pricing, with the other requests sent together added
+func (s *spendTracker) checkModel(provider string, pricing *config.Pricing) error {
+	cost := s.cost(pricing)
+	now := time.Now()
+	if err := s.ledger.Check(s.cfg.Budgets, provider, "", cost+s.others.providers[provider], now); err != nil {
+		return err
+	}
+	return s.ledger.Check(s.cfg.Budgets, "", s.profile, cost+s.others.total, now)
+}
+
+// cost returns the estimated input cost of the request at pricing
+func (s *spendTracker) cost(pricing *config.Pricing) float64 {
+	if pricing == nil {
+		return 0
+	}
+	return pricing.Cost(s.tokens, 0)
+}
+
+func (s *spendTracker) fallback() bool {
+	_, ok := s.provider.(*providers.FallbackProvider)
+	return ok
+}
+
+// record adds a completed request to the ledger. A failure only warns, as
+// the answer was already printed.
+func (s *spendTracker) record(usage providers.Usage) {
+	model, pricing := s.model, s.pricing
+	if fallback, ok := s.provider.(*providers.FallbackProvider); ok && fallback.Answered() != "" {
+		// Record the model of the chain that answered
+		model = fallback.Answered()
+		pricing = modelPricing(s.cfg, model)
+	}
+
+	entry := ledger.Entry{
+		Time:         time.Now(),
+		Provider:     s.provider.Name(),
+		Model:        model,
+		Command:      s.command,
+		Profile:      s.profile,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+	}
+	if pricing != nil {
+		entry.Cost = pricing.Cost(usage.InputTokens, usage.OutputTokens)
+	}
+	if err := s.ledger.Record(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record spend: %v\n", err)
+	}
+}
+
+// reportUsage prints the usage of an answer not printed by
+// providers.ProcessRequest if asked to, and records its spend
+func reportUsage(req *providers.Request, usage providers.Usage) {
+	if req.ShowUsage {
+		fmt.Fprintln(os.Stderr, providers.FormatUsage(usage, req.Pricing))
+	}
+	if req.OnUsage != nil {
+		req.OnUsage(usage)
+	}
+}
+
+// spendRow is the total spend of one group of ledger entries
+type spendRow struct {
+	key      string
+	cost     float64
+	requests int
+}
+
+// groupSpend sums the entries per key
+func groupSpend(entries []ledger.Entry, key func(ledger.Entry) string) []spendRow {
+	index := make(map[string]int)
+	var rows []spendRow
+	for _, entry := range entries {
+		k := key(entry)
+		i, ok := index[k]
+		if !ok {
+			i = len(rows)
+			index[k] = i
+			rows = append(rows, spendRow{key: k})
+		}
+		rows[i].cost += entry.Cost
+		rows[i].requests++
+	}
+	return rows
+}
+
+func newUsageCmd() *cobra.Command {
+	usageCmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Report the recorded spend by day, model and command",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			book, err := ledger.Default()
+			if err != nil {
+				return err
+			}
+			entries, err := book.Entries()
+			if err != nil {
+				return fmt.Errorf("failed to read spend ledger: %w", err)
+			}
+
+			days, _ := cmd.Flags().GetInt("days")
+			writeUsageReport(cmd.OutOrStdout(), entries, cfg.Budgets, days, time.Now())
+			return nil
+		},
+	}
+	usageCmd.Flags().Int("days", 30, "Number of days to report")
+	return usageCmd
+}
+
+// writeUsageReport prints the spend of the last days, grouped by day, model
+// and command, followed by the state of the configured budgets
+func writeUsageReport(out io.Writer, all []ledger.Entry, budgets config.Budgets, days int, now time.Time) {
+	since := ledger.StartOfDay(now).AddDate(0, 0, 1-days)
+	var entries []ledger.Entry
+	var total float64
+	for _, entry := range all {
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+			total += entry.Cost
+		}
+	}
+
+	fmt.Fprintf(out, "Spend over the last %d days: $%.4f in %d requests\n", days, total, len(entries))
+	if len(entries) > 0 {
+		byDay := groupSpend(entries, func(e ledger.Entry) string { return e.Time.In(now.Location()).Format("2006-01-02") })
+		sort.Slice(byDay, func(i, j int) bool { return byDay[i].key < byDay[j].key })
+		writeSpendRows(out, "By day", byDay)
+
+		byCost := func(rows []spendRow) []spendRow {
+			sort.SliceStable(rows, func(i, j int) bool { return rows[i].cost > rows[j].cost })
+			return rows
+		}
+		writeSpendRows(out, "By model", byCost(groupSpend(entries, func(e ledger.Entry) string { return e.Model })))
+		writeSpendRows(out, "By command", byCost(groupSpend(entries, func(e ledger.Entry) string { return e.Command })))
+	}
+
+	lines := budgetLines(all, "provider", budgets.Providers, func(name string) func(ledger.Entry) bool {
+		return func(e ledger.Entry) bool { return strings.EqualFold(e.Provider, name) }
+	}, now)
+	lines = append(lines, budgetLines(all, "profile", budgets.Profiles, func(name string) func(ledger.Entry) bool {
+		return func(e ledger.Entry) bool { return strings.EqualFold(e.Profile, name) }
+	}, now)...)
+	if len(lines) > 0 {
+		fmt.Fprintln(out, "\nBudgets:")
+		for _, line := range lines {
+			fmt.Fprintln(out, line)
+		}
+	}
+}
+
+func writeSpendRows(out io.Writer, title string, rows []spendRow) {
+	fmt.Fprintf(out, "\n%s:\n", title)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintf(w, "  %s\t$%.4f\t%d requests\n", row.key, row.cost, row.requests)
+	}
+	w.Flush()
+}
+
+// budgetLines describes today's and this month's spend against each budget
+func budgetLines(entries []ledger.Entry, kind string, budgets map[string]config.Budget, match func(string) func(ledger.Entry) bool, now time.Time) []string {
+	names := make([]string, 0, len(budgets))
+	for name := range budgets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		budget := budgets[name]
+		today := ledger.Spent(entries, ledger.StartOfDay(now), match(name))
+		month := ledger.Spent(entries, ledger.StartOfMonth(now), match(name))
+		lines = append(lines, fmt.Sprintf("  %s %s: $%.4f today%s, $%.4f this month%s",
+			kind, name, today, budgetLimit(budget.Daily), month, budgetLimit(budget.Monthly)))
+	}
+	return lines
+}
+
+func budgetLimit(limit float64) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" of $%.2f", limit)
+}
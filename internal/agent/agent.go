@@ -0,0 +1,131 @@
+// internal/agent/agent.go
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/pkg/chat"
+)
+
+const (
+	// DefaultMaxSteps bounds the turns of the model in one run, so a model
+	// that keeps calling tools cannot loop forever
+	DefaultMaxSteps = 10
+	// maxOutput is the most of a tool output that is sent back to the model
+	maxOutput = 32 * 1024
+)
+
+// Agent answers a request with a model that may call tools to inspect the
+// system first. Every call is shown to the user and only runs once approved.
+type Agent struct {
+	Provider providers.ToolCaller
+	Tools    []Tool
+	// Approve asks the user a yes/no question
+	Approve func(question string) (bool, error)
+	// Log receives the tool calls and the notes of the model between them
+	Log      io.Writer
+	MaxSteps int
+}
+
+// New returns an agent with the built-in tools
+func New(provider providers.ToolCaller, approve func(question string) (bool, error)) *Agent {
+	return &Agent{
+		Provider: provider,
+		Tools:    Builtin(),
+		Approve:  approve,
+		Log:      os.Stderr,
+		MaxSteps: DefaultMaxSteps,
+	}
+}
+
+// Run sends the request and runs the tool calls the model asks for until it
+// answers without calling any. It returns the answer and the usage summed
+// over all turns.
+func (a *Agent) Run(ctx context.Context, req *providers.Request) (string, providers.Usage, error) {
+	req.Tools = make([]providers.Tool, 0, len(a.Tools))
+	for _, tool := range a.Tools {
+		req.Tools = append(req.Tools, tool.Tool)
+	}
+
+	var total providers.Usage
+	for step := 0; step < a.MaxSteps; step++ {
+		resp, err := a.Provider.CompleteWithTools(ctx, req)
+		if err != nil {
+			return "", total, err
+		}
+		total.Add(resp.Usage)
+		if len(resp.ToolCalls) == 0 {
+			return resp.Content, total, nil
+		}
+
+		if resp.Content != "" {
+			fmt.Fprintln(a.Log, resp.Content)
+		}
+		req.Messages = append(req.Messages, chat.Message{
+			Role:      chat.RoleAssistant,
+			Content:   resp.Content,
+			ToolCalls: resp.ToolCalls,
+		})
+		for _, call := range resp.ToolCalls {
+			result, err := a.call(ctx, call)
+			if err != nil {
+				return "", total, err
+			}
+			if err := ctx.Err(); err != nil {
+				return "", total, err
+			}
+			req.Messages = append(req.Messages, chat.Message{
+				Role:       chat.RoleTool,
+				Content:    result,
+				ToolCallID: call.ID,
+			})
+		}
+	}
+	return "", total, fmt.Errorf("no answer after %d steps of tool calls", a.MaxSteps)
+}
+
+// call shows a tool call, asks for approval and runs it. The result is the
+// message for the model, including failures, so it can react to them. The
+// error is that of an approval that could not be asked, which stops the
+// agent.
+func (a *Agent) call(ctx context.Context, call chat.ToolCall) (string, error) {
+	tool, ok := a.tool(call.Name)
+	if !ok {
+		return fmt.Sprintf("Unknown tool %s", call.Name), nil
+	}
+	args, err := providers.ParseToolArguments(call)
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	fmt.Fprintln(a.Log, tool.Describe(args))
+	approved, err := a.Approve(fmt.Sprintf("Allow %s?", call.Name))
+	if err != nil {
+		return "", fmt.Errorf("failed to ask for approval of %s: %w", call.Name, err)
+	}
+	if !approved {
+		return "The user denied this tool call.", nil
+	}
+
+	output, err := tool.Run(ctx, args)
+	if err != nil {
+		output += fmt.Sprintf("\nError: %v", err)
+	}
+	if len(output) > maxOutput {
+		output = output[:maxOutput] + "\n[output truncated]"
+	}
+	return output, nil
+}
+
+func (a *Agent) tool(name string) (Tool, bool) {
+	for _, tool := range a.Tools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
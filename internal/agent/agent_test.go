@@ -0,0 +1,100 @@
+// internal/agent/agent_test.go
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/acazau/shell-ask-go/internal/providers"
+	"github.com/acazau/shell-ask-go/pkg/chat"
+)
+
+// scriptedModel answers with the responses in order and keeps the requests
+type scriptedModel struct {
+	responses []*providers.ToolResponse
+	requests  [][]chat.Message
+}
+
+func (m *scriptedModel) CompleteWithTools(ctx context.Context, req *providers.Request) (*providers.ToolResponse, error) {
+	m.requests = append(m.requests, append([]chat.Message(nil), req.Messages...))
+	resp := m.responses[0]
+	m.responses = m.responses[1:]
+	return resp, nil
+}
+
+func TestAgentRunsApprovedCalls(t *testing.T) {
+	model := &scriptedModel{responses: []*providers.ToolResponse{
+		{
+			ToolCalls: []chat.ToolCall{
+				{ID: "1", Name: "run_shell", Arguments: `{"command":"echo hello"}`},
+				{ID: "2", Name: "run_shell", Arguments: `{"command":"echo denied"}`},
+			},
+			Usage: providers.Usage{InputTokens: 10, OutputTokens: 5},
+		},
+		{Content: "The system says hello", Usage: providers.Usage{InputTokens: 20, OutputTokens: 7}},
+	}}
+
+	var questions int
+	a := New(model, func(question string) (bool, error) {
+		questions++
+		// Approve only the first call
+		return questions == 1, nil
+	})
+	a.Log = io.Discard
+
+	answer, usage, err := a.Run(context.Background(), providers.NewRequest("say hello", false))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if answer != "The system says hello" {
+		t.Errorf("expected the final answer, got %q", answer)
+	}
+	if usage.InputTokens != 30 || usage.OutputTokens != 12 {
+		t.Errorf("expected usage summed over turns, got %+v", usage)
+	}
+
+	messages := model.requests[1]
+	if len(messages) != 4 {
+		t.Fatalf("expected prompt, tool calls and 2 results, got %d messages", len(messages))
+	}
+	if messages[2].ToolCallID != "1" || strings.TrimSpace(messages[2].Content) != "hello" {
+		t.Errorf("expected the output of the approved call, got %+v", messages[2])
+	}
+	if !strings.Contains(messages[3].Content, "denied") || messages[3].ToolCallID != "2" {
+		t.Errorf("expected the denied call to be reported, got %+v", messages[3])
+	}
+}
+
+func TestAgentStopsWhenApprovalFails(t *testing.T) {
+	model := &scriptedModel{responses: []*providers.ToolResponse{
+		{ToolCalls: []chat.ToolCall{{ID: "1", Name: "read_file", Arguments: `{"path":"/etc/hostname"}`}}},
+	}}
+	a := New(model, func(string) (bool, error) { return false, io.EOF })
+	a.Log = io.Discard
+
+	if _, _, err := a.Run(context.Background(), providers.NewRequest("hostname?", false)); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the failed approval to stop the agent, got %v", err)
+	}
+	if len(model.requests) != 1 {
+		t.Errorf("expected no further turn after the failed approval, got %d requests", len(model.requests))
+	}
+}
+
+func TestAgentStopsAfterMaxSteps(t *testing.T) {
+	call := &providers.ToolResponse{ToolCalls: []chat.ToolCall{{ID: "1", Name: "unknown_tool"}}}
+	model := &scriptedModel{responses: []*providers.ToolResponse{call, call}}
+
+	a := New(model, func(string) (bool, error) { return true, nil })
+	a.Log = io.Discard
+	a.MaxSteps = 2
+
+	if _, _, err := a.Run(context.Background(), providers.NewRequest("loop", false)); err == nil {
+		t.Error("expected an error after the maximum number of steps")
+	}
+	if got := model.requests[1][2].Content; !strings.Contains(got, "Unknown tool") {
+		t.Errorf("expected an unknown tool to be reported to the model, got %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+// internal/agent/tools.go
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/providers"
+)
+
+const (
+	// shellTimeout bounds how long a command of run_shell may run
+	shellTimeout = 2 * time.Minute
+	// maxFileSize is the most read_file returns of a file
+	maxFileSize = 64 * 1024
+)
+
+// Tool is a tool the model may call, with the functions showing and
+// running a call
+type Tool struct {
+	providers.Tool
+	// Describe shows a call to the user before it is approved
+	Describe func(args map[string]interface{}) string
+	// Run executes an approved call and returns its output for the model
+	Run func(ctx context.Context, args map[string]interface{}) (string, error)
+}
+
+// Builtin returns the tools available to the agent
+func Builtin() []Tool {
+	return []Tool{runShell(), readFile()}
+}
+
+func runShell() Tool {
+	return Tool{
+		Tool: providers.Tool{
+			Name:        "run_shell",
+			Description: "Run a shell command with sh -c on the user's system and return its combined stdout and stderr. Prefer read-only commands to inspect the system.",
+			Parameters: []providers.ToolParameter{
+				{Name: "command", Type: "string", Description: "The shell command to run", Required: true},
+			},
+		},
+		Describe: func(args map[string]interface{}) string {
+			return fmt.Sprintf("Run: %s", stringArg(args, "command"))
+		},
+		Run: func(ctx context.Context, args map[string]interface{}) (string, error) {
+			command := stringArg(args, "command")
+			if command == "" {
+				return "", fmt.Errorf("no command given")
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, shellTimeout)
+			defer cancel()
+			output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+			return string(output), err
+		},
+	}
+}
+
+func readFile() Tool {
+	return Tool{
+		Tool: providers.Tool{
+			Name:        "read_file",
+			Description: fmt.Sprintf("Read a text file on the user's system. Only the first %d KB are returned.", maxFileSize/1024),
+			Parameters: []providers.ToolParameter{
+				{Name: "path", Type: "string", Description: "Path of the file to read", Required: true},
+			},
+		},
+		Describe: func(args map[string]interface{}) string {
+			return fmt.Sprintf("Read file: %s", stringArg(args, "path"))
+		},
+		Run: func(ctx context.Context, args map[string]interface{}) (string, error) {
+			path := stringArg(args, "path")
+			if path == "" {
+				return "", fmt.Errorf("no path given")
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+
+			data, err := io.ReadAll(io.LimitReader(f, maxFileSize+1))
+			if err != nil {
+				return "", err
+			}
+			if len(data) > maxFileSize {
+				return string(data[:maxFileSize]) + "\n[file truncated]", nil
+			}
+			return string(data), nil
+		},
+	}
+}
+
+// stringArg returns the string argument name of a call, or an empty string
+func stringArg(args map[string]interface{}, name string) string {
+	value, _ := args[name].(string)
+	return value
+}
@@ -0,0 +1,345 @@
+// internal/cassette/cassette.go
+package cassette
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acazau/shell-ask-go/internal/httpclient"
+)
+
+// Cassette is a recorded HTTP exchange. Secrets in the request and response
+// headers and in the URL are redacted.
+type Cassette struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the recorded request. The body is left out if it is not text.
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response is the recorded response with its body in the chunks it arrived
+// in, so a replay keeps the timing of streamed answers
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	// LatencyMS is the wait for the response headers
+	LatencyMS int64   `json:"latency_ms"`
+	Chunks    []Chunk `json:"chunks"`
+}
+
+// Chunk is a piece of the response body and the wait before it, since the
+// previous chunk or the headers. Text is kept as is, other data in Base64.
+type Chunk struct {
+	DelayMS int64  `json:"delay_ms"`
+	Data    string `json:"data,omitempty"`
+	Base64  []byte `json:"base64,omitempty"`
+}
+
+func (c Chunk) bytes() []byte {
+	if c.Base64 != nil {
+		return c.Base64
+	}
+	return []byte(c.Data)
+}
+
+// sequence names the cassettes of a session. Requests are matched by method,
+// URL and body, and repeated requests by the order they were made in.
+type sequence struct {
+	dir  string
+	mu   sync.Mutex
+	seen map[string]int
+}
+
+func (s *sequence) path(method, url string, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", method, url)
+	hash.Write(body)
+	key := hex.EncodeToString(hash.Sum(nil))[:16]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.seen[key]
+	s.seen[key] = n + 1
+	return filepath.Join(s.dir, fmt.Sprintf("%s-%d.json", key, n))
+}
+
+// readBody reads and closes the body of req
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	return io.ReadAll(req.Body)
+}
+
+// normalize compacts JSON bodies, as some SDKs vary the whitespace of their
+// JSON between builds
+func normalize(body []byte) []byte {
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) == nil {
+		return compact.Bytes()
+	}
+	return body
+}
+
+// Recorder is a transport saving every exchange to a cassette file in its
+// directory. Failed requests are not recorded.
+type Recorder struct {
+	base     http.RoundTripper
+	sequence *sequence
+}
+
+// NewRecorder returns a recorder sending the requests with base and saving
+// them to dir, which is created if needed
+func NewRecorder(dir string, base http.RoundTripper) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	return &Recorder{base: base, sequence: &sequence{dir: dir, seen: make(map[string]int)}}, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	body = normalize(body)
+	url := httpclient.RedactURL(req.URL)
+	path := r.sequence.path(req.Method, url, body)
+
+	start := time.Now()
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	cassette := &Cassette{
+		Request: Request{
+			Method: req.Method,
+			URL:    url,
+			Header: httpclient.RedactHeader(req.Header),
+		},
+		Response: Response{
+			Status:    resp.StatusCode,
+			Header:    httpclient.RedactHeader(resp.Header),
+			LatencyMS: time.Since(start).Milliseconds(),
+		},
+	}
+	if utf8.Valid(body) {
+		cassette.Request.Body = string(body)
+	}
+	resp.Body = &recordingBody{body: resp.Body, cassette: cassette, path: path, last: time.Now()}
+	return resp, nil
+}
+
+// recordingBody adds what is read to the cassette and saves it once the
+// body is read to the end or closed
+type recordingBody struct {
+	body     io.ReadCloser
+	cassette *Cassette
+	path     string
+	last     time.Time
+	once     sync.Once
+	saveErr  error
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.add(p[:n])
+	}
+	if err == io.EOF {
+		if saveErr := b.save(); saveErr != nil {
+			return n, saveErr
+		}
+	}
+	return n, err
+}
+
+func (b *recordingBody) add(data []byte) {
+	now := time.Now()
+	delay := now.Sub(b.last).Milliseconds()
+	b.last = now
+
+	chunks := &b.cassette.Response.Chunks
+	text := utf8.Valid(data)
+	// Chunks arriving together are merged to keep the file readable
+	if last := len(*chunks) - 1; last >= 0 && delay == 0 && text && (*chunks)[last].Base64 == nil {
+		(*chunks)[last].Data += string(data)
+		return
+	}
+	if text {
+		*chunks = append(*chunks, Chunk{DelayMS: delay, Data: string(data)})
+	} else {
+		*chunks = append(*chunks, Chunk{DelayMS: delay, Base64: append([]byte(nil), data...)})
+	}
+}
+
+func (b *recordingBody) save() error {
+	b.once.Do(func() {
+		b.cassette.redactBodies()
+		data, err := json.MarshalIndent(b.cassette, "", "  ")
+		if err == nil {
+			err = os.WriteFile(b.path, data, 0644)
+		}
+		if err != nil {
+			b.saveErr = fmt.Errorf("failed to save recording: %w", err)
+		}
+	})
+	return b.saveErr
+}
+
+// redactBodies replaces the secret JSON fields of the bodies, such as the
+// token of a token exchange. A response body with secrets is saved as one
+// chunk arriving after all the delays.
+func (c *Cassette) redactBodies() {
+	if body, changed := httpclient.RedactBody([]byte(c.Request.Body)); changed {
+		c.Request.Body = string(body)
+	}
+
+	var body []byte
+	var delay int64
+	for _, chunk := range c.Response.Chunks {
+		if chunk.Base64 != nil {
+			return
+		}
+		body = append(body, chunk.Data...)
+		delay += chunk.DelayMS
+	}
+	if redacted, changed := httpclient.RedactBody(body); changed {
+		c.Response.Chunks = []Chunk{{DelayMS: delay, Data: string(redacted)}}
+	}
+}
+
+// Close saves what was read so far, so an answer cut short is replayed as
+// far as it got
+func (b *recordingBody) Close() error {
+	err := b.body.Close()
+	if saveErr := b.save(); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// Replayer is a transport answering requests from the cassettes of its
+// directory, without any network access. Requests that were not recorded
+// fail.
+type Replayer struct {
+	sequence *sequence
+	// sleep waits for d or until ctx is done
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewReplayer returns a replayer for the cassettes in dir
+func NewReplayer(dir string) (*Replayer, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recordings: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("recordings %s is not a directory", dir)
+	}
+	return &Replayer{sequence: &sequence{dir: dir, seen: make(map[string]int)}, sleep: sleepContext}, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	url := httpclient.RedactURL(req.URL)
+	path := r.sequence.path(req.Method, url, normalize(body))
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recording of %s %s in %s", req.Method, url, r.sequence.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("invalid recording %s: %w", path, err)
+	}
+
+	ctx := req.Context()
+	if err := r.sleep(ctx, time.Duration(cassette.Response.LatencyMS)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", cassette.Response.Status, http.StatusText(cassette.Response.Status)),
+		StatusCode: cassette.Response.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     cassette.Response.Header,
+		Body:       &replayBody{ctx: ctx, chunks: cassette.Response.Chunks, sleep: r.sleep},
+		Request:    req,
+	}, nil
+}
+
+// replayBody hands out the recorded chunks, each after its delay
+type replayBody struct {
+	ctx     context.Context
+	chunks  []Chunk
+	pending []byte
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	if len(b.pending) == 0 {
+		if len(b.chunks) == 0 {
+			return 0, io.EOF
+		}
+		chunk := b.chunks[0]
+		b.chunks = b.chunks[1:]
+		if err := b.sleep(b.ctx, time.Duration(chunk.DelayMS)*time.Millisecond); err != nil {
+			return 0, err
+		}
+		b.pending = chunk.bytes()
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *replayBody) Close() error {
+	b.chunks = nil
+	b.pending = nil
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+// internal/cassette/cassette_test.go
+package cassette
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"text\":\"Hello\"}\n\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(30 * time.Millisecond)
+		w.Write([]byte("data: {\"text\":\" world\"}\n\n"))
+	}))
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := send(t, recorder, server.URL+"/v1/chat?key=sk-secret", `{"model": "gpt-4o", "stream": true}`)
+	server.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("expected one cassette, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if strings.Contains(string(data), "sk-secret") {
+		t.Errorf("expected the keys to be scrubbed:\n%s", data)
+	}
+
+	// The server is gone, and the JSON whitespace may differ
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delays []time.Duration
+	replayer.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	replayed := send(t, replayer, server.URL+"/v1/chat?key=other", `{"model":"gpt-4o","stream":true}`)
+	if replayed != recorded {
+		t.Errorf("expected the recorded body %q, got %q", recorded, replayed)
+	}
+	if len(delays) < 3 || delays[len(delays)-1] < 20*time.Millisecond {
+		t.Errorf("expected the pause before the second event to be kept, got %v", delays)
+	}
+
+	// The request was recorded once, so it cannot be replayed twice
+	req, _ := http.NewRequest("POST", server.URL+"/v1/chat?key=other", strings.NewReader(`{"model":"gpt-4o","stream":true}`))
+	if _, err := replayer.RoundTrip(req); err == nil {
+		t.Error("expected an error for a request replayed more often than recorded")
+	}
+}
+
+func TestRecordScrubsTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"token":"tid=copilot-secret","expires_at":1700000000}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL+"/copilot_internal/v2/token", nil)
+	resp, err := (&http.Client{Transport: recorder}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("expected one cassette, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if strings.Contains(string(data), "copilot-secret") {
+		t.Errorf("expected the token to be scrubbed:\n%s", data)
+	}
+	if !strings.Contains(string(data), `\"token\":\"[redacted]\"`) || !strings.Contains(string(data), "1700000000") {
+		t.Errorf("expected only the token to be redacted:\n%s", data)
+	}
+}
+
+func send(t *testing.T, transport http.RoundTripper, url, body string) string {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %s %v", resp.Status, resp.Header)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	return string(data)
+}
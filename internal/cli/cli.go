@@ -56,6 +56,12 @@ func (cli *CLI) Execute() error {
 }
 
 func (cli *CLI) handleAsk(cmd *cobra.Command, args []string) error {
+	return cli.ask(cmd, args, cli.config.SystemPrompt)
+}
+
+// ask sends the prompt built from args and piped input with the given
+// system prompt
+func (cli *CLI) ask(cmd *cobra.Command, args []string, system string) error {
 	if len(args) == 0 && !utils.IsPiped() {
 		return fmt.Errorf("please provide a prompt")
 	}
@@ -90,8 +96,11 @@ func (cli *CLI) handleAsk(cmd *cobra.Command, args []string) error {
 		prompt += "\nReturn the command only without any other text."
 	}
 
+	req := providers.NewRequest(prompt, !noStream)
+	req.System = system
+
 	// Process request
-	return cli.processRequest(cmd.Context(), provider, prompt, !noStream)
+	return cli.processRequest(cmd.Context(), provider, req)
 }
 
 // In cli.go, modify getProvider:
@@ -120,11 +129,7 @@ func (cli *CLI) getProvider(modelID string) (providers.Provider, error) {
 		modelID = selectOption("Select a model:", cli.config.AvailableModels)
 	}
 
-	model := models.SelectModel(modelID)
-	switch {
-	case strings.HasPrefix(model, "gpt"):
-		return providers.NewOpenAIProvider(cli.config.OpenAIKey, model)
-	case strings.HasPrefix(model, "claude"):
+	if model := models.SelectModel(modelID); strings.HasPrefix(model, "claude") {
 		confirmed, err := askYesNo(fmt.Sprintf("Use %s model?", model))
 		if err != nil {
 			return nil, err
@@ -132,14 +137,12 @@ func (cli *CLI) getProvider(modelID string) (providers.Provider, error) {
 		if !confirmed {
 			return nil, fmt.Errorf("model selection cancelled")
 		}
-		return providers.NewAnthropicProvider(cli.config.AnthropicKey, model), nil
-	default:
-		return nil, fmt.Errorf("unsupported model: %s", model)
 	}
+	return providers.InitializeProviderWithConfig(cli.config, modelID)
 }
 
-func (cli *CLI) processRequest(ctx context.Context, provider providers.Provider, prompt string, stream bool) error {
-	reader, err := provider.Complete(ctx, prompt, stream)
+func (cli *CLI) processRequest(ctx context.Context, provider providers.Provider, req *providers.Request) error {
+	reader, err := provider.Complete(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -178,7 +181,11 @@ func (cli *CLI) addCustomCommands() {
 				if c.RequireStdin && !utils.IsPiped() {
 					return fmt.Errorf("this command requires piped input")
 				}
-				return cli.handleAsk(cmd, []string{c.Prompt})
+				system := c.System
+				if system == "" {
+					system = cli.config.SystemPrompt
+				}
+				return cli.ask(cmd, []string{c.Prompt}, system)
 			},
 		}
 		cli.rootCmd.AddCommand(customCmd)
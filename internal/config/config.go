@@ -2,22 +2,187 @@
 package config
 
 import (
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	DefaultModel    string          `json:"default_model" mapstructure:"default_model"`
-	AvailableModels []string        `json:"available_models" mapstructure:"available_models"` // New field
-	OpenAIKey       string          `json:"openai_api_key" mapstructure:"openai_api_key"`
-	OpenAIURL       string          `json:"openai_api_url" mapstructure:"openai_api_url"`
-	AnthropicKey    string          `json:"anthropic_api_key" mapstructure:"anthropic_api_key"`
-	GeminiKey       string          `json:"gemini_api_key" mapstructure:"gemini_api_key"`
-	GroqKey         string          `json:"groq_api_key" mapstructure:"groq_api_key"`
-	OllamaHost      string          `json:"ollama_host" mapstructure:"ollama_host"`
-	Commands        []CustomCommand `json:"commands" mapstructure:"commands"`
+	DefaultModel    string            `json:"default_model" mapstructure:"default_model"`
+	AvailableModels []string          `json:"available_models" mapstructure:"available_models"` // New field
+	OpenAIKey       string            `json:"openai_api_key" mapstructure:"openai_api_key"`
+	OpenAIURL       string            `json:"openai_api_url" mapstructure:"openai_api_url"`
+	AnthropicKey    string            `json:"anthropic_api_key" mapstructure:"anthropic_api_key"`
+	GeminiKey       string            `json:"gemini_api_key" mapstructure:"gemini_api_key"`
+	GeminiSafety    map[string]string `json:"gemini_safety_settings" mapstructure:"gemini_safety_settings"`
+	GroqKey         string            `json:"groq_api_key" mapstructure:"groq_api_key"`
+	OllamaHost      string            `json:"ollama_host" mapstructure:"ollama_host"`
+	Commands        []CustomCommand   `json:"commands" mapstructure:"commands"`
+	// SystemPrompt is sent with every request unless overridden
+	SystemPrompt string `json:"system_prompt" mapstructure:"system_prompt"`
+	// Endpoints are named OpenAI-compatible servers, selected with -m name/model
+	Endpoints map[string]Endpoint `json:"endpoints" mapstructure:"endpoints"`
+	// Generation holds the default generation parameters for every model
+	Generation GenerationOptions `json:"generation" mapstructure:"generation"`
+	// ModelOptions override the generation parameters for specific models
+	ModelOptions []ModelOptions `json:"model_options" mapstructure:"model_options"`
+	// Pricing overrides the built-in model prices, keyed by model ID
+	Pricing map[string]Pricing `json:"pricing" mapstructure:"pricing"`
+	// ConfirmCost is the estimated input cost in USD above which a piped
+	// input or --files set needs confirmation. Zero disables the check.
+	ConfirmCost float64 `json:"confirm_cost" mapstructure:"confirm_cost"`
+	// Profile names who or what the spend is recorded for, used to share
+	// keys with separate budgets
+	Profile string `json:"profile" mapstructure:"profile"`
+	// Budgets limit the recorded spend per provider and per profile
+	Budgets Budgets `json:"budgets" mapstructure:"budgets"`
+	// Retries is how often a rate-limited or failed request is retried
+	Retries int `json:"retries" mapstructure:"retries"`
+	// Fallbacks are named chains of model IDs, selected with -m name. The
+	// next model is tried when one is unavailable.
+	Fallbacks map[string][]string `json:"fallbacks" mapstructure:"fallbacks"`
+	// HTTP configures the connections of every HTTP client
+	HTTP HTTPConfig `json:"http" mapstructure:"http"`
+}
+
+// HTTPConfig holds the proxy, TLS, timeout and header settings shared by all
+// HTTP requests. Durations are given as strings such as "10s".
+type HTTPConfig struct {
+	// Proxy is the proxy URL for all requests. Empty falls back to the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+	Proxy string `json:"proxy" mapstructure:"proxy"`
+	// NoProxy lists the hosts reached without the configured proxy, in the
+	// format of NO_PROXY
+	NoProxy string `json:"no_proxy" mapstructure:"no_proxy"`
+	// CACert is a PEM bundle of certificate authorities trusted in addition
+	// to the system ones
+	CACert string `json:"ca_cert" mapstructure:"ca_cert"`
+	// ClientCert and ClientKey are the PEM files of a TLS client certificate.
+	// The key may be left out when it is in the certificate file.
+	ClientCert string `json:"client_cert" mapstructure:"client_cert"`
+	ClientKey  string `json:"client_key" mapstructure:"client_key"`
+	// ConnectTimeout bounds establishing a connection, including the TLS
+	// handshake
+	ConnectTimeout time.Duration `json:"connect_timeout" mapstructure:"connect_timeout"`
+	// ReadTimeout bounds the wait for the response headers and for each
+	// read of the body, so streamed answers may take longer in total
+	ReadTimeout time.Duration `json:"read_timeout" mapstructure:"read_timeout"`
+	// Headers are added to every request that does not set them itself.
+	// Environment variables in the values are expanded.
+	Headers map[string]string `json:"headers" mapstructure:"headers"`
+	// Transport, if set, replaces the network connection, as for dry runs.
+	// It is not read from the config file.
+	Transport http.RoundTripper `json:"-" mapstructure:"-"`
+}
+
+// DefaultRetries is the number of retries used when none is configured
+const DefaultRetries = 2
+
+// Budgets are spend limits keyed by provider name and by profile
+type Budgets struct {
+	Providers map[string]Budget `json:"providers" mapstructure:"providers"`
+	Profiles  map[string]Budget `json:"profiles" mapstructure:"profiles"`
+}
+
+// Budget is a spend limit in USD per day and per calendar month. Zero means
+// no limit.
+type Budget struct {
+	Daily   float64 `json:"daily" mapstructure:"daily"`
+	Monthly float64 `json:"monthly" mapstructure:"monthly"`
+}
+
+// DefaultConfirmCost is the confirmation threshold used when none is
+// configured
+const DefaultConfirmCost = 0.10
+
+// Pricing is the price of a model in USD per million tokens
+type Pricing struct {
+	Input  float64 `json:"input" mapstructure:"input"`
+	Output float64 `json:"output" mapstructure:"output"`
+}
+
+// Cost returns the price of the given token counts in USD
+func (p Pricing) Cost(inputTokens, outputTokens int) float64 {
+	return (float64(inputTokens)*p.Input + float64(outputTokens)*p.Output) / 1e6
+}
+
+// PricingFor returns the configured price override for a model
+func (c *Config) PricingFor(model string) (Pricing, bool) {
+	// viper lowercases map keys, so model IDs are matched in lowercase
+	pricing, ok := c.Pricing[strings.ToLower(model)]
+	return pricing, ok
+}
+
+// FallbackChain returns the models of the fallback chain with the given name
+func (c *Config) FallbackChain(name string) ([]string, bool) {
+	// viper lowercases map keys, so chain names are matched in lowercase
+	chain, ok := c.Fallbacks[strings.ToLower(name)]
+	return chain, ok
+}
+
+// GenerationOptions are the sampling parameters of a request. Unset fields
+// leave the choice to the provider.
+type GenerationOptions struct {
+	Temperature *float64 `json:"temperature,omitempty" mapstructure:"temperature"`
+	MaxTokens   *int     `json:"max_tokens,omitempty" mapstructure:"max_tokens"`
+	TopP        *float64 `json:"top_p,omitempty" mapstructure:"top_p"`
+	Stop        []string `json:"stop,omitempty" mapstructure:"stop"`
+	Seed        *int     `json:"seed,omitempty" mapstructure:"seed"`
+}
+
+// Merge returns the options with the fields set in override replacing
+// their counterparts
+func (o GenerationOptions) Merge(override GenerationOptions) GenerationOptions {
+	if override.Temperature != nil {
+		o.Temperature = override.Temperature
+	}
+	if override.MaxTokens != nil {
+		o.MaxTokens = override.MaxTokens
+	}
+	if override.TopP != nil {
+		o.TopP = override.TopP
+	}
+	if len(override.Stop) > 0 {
+		o.Stop = override.Stop
+	}
+	if override.Seed != nil {
+		o.Seed = override.Seed
+	}
+	return o
+}
+
+// ModelOptions are generation parameters for one model. Model is matched
+// against the model ID as given on the command line.
+type ModelOptions struct {
+	Model             string `json:"model" mapstructure:"model"`
+	GenerationOptions `mapstructure:",squash"`
+}
+
+// GenerationOptionsFor returns the global generation parameters merged with
+// the ones configured for the given model
+func (c *Config) GenerationOptionsFor(model string) GenerationOptions {
+	options := c.Generation
+	for _, m := range c.ModelOptions {
+		if m.Model == model {
+			options = options.Merge(m.GenerationOptions)
+		}
+	}
+	return options
+}
+
+// Endpoint describes a server implementing the OpenAI chat completions API.
+// Environment variables in APIKey are expanded, so "$LOCAL_API_KEY" keeps the
+// key out of the config file. JSONSchema is set for servers accepting a JSON
+// Schema response format, which --type then uses.
+type Endpoint struct {
+	BaseURL    string            `json:"base_url" mapstructure:"base_url"`
+	APIKey     string            `json:"api_key" mapstructure:"api_key"`
+	Headers    map[string]string `json:"headers" mapstructure:"headers"`
+	JSONSchema bool              `json:"json_schema" mapstructure:"json_schema"`
 }
 
 type CustomCommand struct {
@@ -25,6 +190,7 @@ type CustomCommand struct {
 	Description  string                 `mapstructure:"description"`
 	Example      string                 `mapstructure:"example"`
 	Prompt       string                 `mapstructure:"prompt"`
+	System       string                 `mapstructure:"system"`
 	Variables    map[string]interface{} `mapstructure:"variables"`
 	RequireStdin bool                   `mapstructure:"require_stdin"`
 }
@@ -45,6 +211,9 @@ func Load() (*Config, error) {
 	// Local config
 	v.AddConfigPath(".")
 
+	v.SetDefault("confirm_cost", DefaultConfirmCost)
+	v.SetDefault("retries", DefaultRetries)
+
 	v.SetConfigName("config")
 	v.SetConfigType("json")
 
@@ -56,3 +56,80 @@ func TestLoadConfig(t *testing.T) {
 		t.Errorf("expected default model %s, got %s", testConfig.DefaultModel, config.DefaultModel)
 	}
 }
+
+func TestGenerationOptionsFor(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, ".config", "shell-ask")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	configData := `{
+		"generation": {"temperature": 0.7, "max_tokens": 2048},
+		"model_options": [
+			{"model": "claude-3.5-sonnet", "temperature": 0.2, "stop": ["END"]}
+		]
+	}`
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(configData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", tempDir)
+
+	config, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := config.GenerationOptionsFor("claude-3.5-sonnet")
+	if options.Temperature == nil || *options.Temperature != 0.2 {
+		t.Errorf("expected model temperature 0.2, got %v", options.Temperature)
+	}
+	if options.MaxTokens == nil || *options.MaxTokens != 2048 {
+		t.Errorf("expected global max tokens 2048, got %v", options.MaxTokens)
+	}
+	if len(options.Stop) != 1 || options.Stop[0] != "END" {
+		t.Errorf("expected stop sequence END, got %v", options.Stop)
+	}
+
+	options = config.GenerationOptionsFor("gpt-4o")
+	if options.Temperature == nil || *options.Temperature != 0.7 {
+		t.Errorf("expected global temperature 0.7, got %v", options.Temperature)
+	}
+
+	seed := 42
+	options = options.Merge(GenerationOptions{Seed: &seed})
+	if options.Seed == nil || *options.Seed != 42 || *options.Temperature != 0.7 {
+		t.Errorf("expected merge to add the seed and keep the temperature, got %+v", options)
+	}
+}
+
+func TestPricing(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, ".config", "shell-ask")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	configData := `{"pricing": {"GPT-4o": {"input": 2.5, "output": 10}}}`
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(configData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", tempDir)
+
+	config, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ConfirmCost != DefaultConfirmCost {
+		t.Errorf("expected default confirmation threshold %v, got %v", DefaultConfirmCost, config.ConfirmCost)
+	}
+
+	pricing, ok := config.PricingFor("gpt-4o")
+	if !ok || pricing.Input != 2.5 || pricing.Output != 10 {
+		t.Fatalf("expected configured pricing, got %+v, %v", pricing, ok)
+	}
+	if cost := pricing.Cost(1000000, 100000); cost != 3.5 {
+		t.Errorf("expected cost 3.5, got %v", cost)
+	}
+}
@@ -13,12 +13,14 @@ import (
 )
 
 const (
-	deviceCodeURL   = "https://github.com/login/device/code"
-	tokenURL        = "https://github.com/login/oauth/access_token"
-	copilotTokenAPI = "https://api.github.com/copilot_internal/v2/token"
-	clientID        = "Iv23liXYYLdDjdQbE0BB" // GitHub Copilot client ID
+	deviceCodeURL = "https://github.com/login/device/code"
+	tokenURL      = "https://github.com/login/oauth/access_token"
+	clientID      = "Iv23liXYYLdDjdQbE0BB" // GitHub Copilot client ID
 )
 
+// TokenAPI exchanges the saved auth token for a Copilot API token
+const TokenAPI = "https://api.github.com/copilot_internal/v2/token"
+
 type Client struct {
 	configDir string
 	client    *http.Client
@@ -46,11 +48,13 @@ type CopilotToken struct {
 	Token string `json:"token"`
 }
 
-func New(configDir string) *Client {
+// New returns a client keeping its tokens in configDir and sending its
+// requests with client
+func New(configDir string, client *http.Client) *Client {
 	log.Printf("Initializing Copilot client with config directory: %s", configDir)
 	return &Client{
 		configDir: configDir,
-		client:    &http.Client{},
+		client:    client,
 	}
 }
 
@@ -207,7 +211,7 @@ func (c *Client) GetAPIToken() (string, error) {
 		return "", fmt.Errorf("no auth token found, please run 'copilot-login' first")
 	}
 
-	req, err := http.NewRequest("GET", copilotTokenAPI, nil)
+	req, err := http.NewRequest("GET", TokenAPI, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
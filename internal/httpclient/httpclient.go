@@ -0,0 +1,207 @@
+// internal/httpclient/httpclient.go
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// New returns an HTTP client using the transport of the HTTP settings
+func New(cfg config.HTTPConfig) (*http.Client, error) {
+	transport, err := NewTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// NewTransport returns the transport every HTTP client of ask is built on.
+// It goes through the configured proxy, or the one of HTTPS_PROXY, HTTP_PROXY
+// and NO_PROXY, trusts the CA bundle on top of the system roots, presents the
+// client certificate, applies the timeouts and adds the extra headers. A
+// configured Transport replaces the connection settings but still gets the
+// headers.
+func NewTransport(cfg config.HTTPConfig) (http.RoundTripper, error) {
+	if cfg.Transport != nil {
+		return withHeaders(cfg.Transport, cfg.Headers), nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc(cfg)
+
+	if cfg.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = cfg.ConnectTimeout
+	}
+	transport.ResponseHeaderTimeout = cfg.ReadTimeout
+
+	tlsConfig, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	var rt http.RoundTripper = transport
+	if cfg.ReadTimeout > 0 {
+		rt = &readTimeoutTransport{base: rt, timeout: cfg.ReadTimeout}
+	}
+	return withHeaders(rt, cfg.Headers), nil
+}
+
+func withHeaders(rt http.RoundTripper, headers map[string]string) http.RoundTripper {
+	if len(headers) == 0 {
+		return rt
+	}
+	return &headerTransport{base: rt, headers: headers}
+}
+
+// proxyFunc returns the proxy selection. A configured proxy replaces the
+// proxy environment variables, while NO_PROXY still applies unless no_proxy
+// is configured too.
+func proxyFunc(cfg config.HTTPConfig) func(*http.Request) (*url.URL, error) {
+	if cfg.Proxy == "" {
+		return http.ProxyFromEnvironment
+	}
+	noProxy := cfg.NoProxy
+	if noProxy == "" {
+		noProxy = os.Getenv("NO_PROXY")
+		if noProxy == "" {
+			noProxy = os.Getenv("no_proxy")
+		}
+	}
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  cfg.Proxy,
+		HTTPSProxy: cfg.Proxy,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+func tlsConfig(cfg config.HTTPConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" {
+		// The key may be in the same PEM file as the certificate
+		key := cfg.ClientKey
+		if key == "" {
+			key = cfg.ClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if cfg.ClientKey != "" {
+		return nil, fmt.Errorf("client_key is set without client_cert")
+	}
+	return tlsConfig, nil
+}
+
+// headerTransport adds the configured headers to requests not setting them
+// already, so they never replace the authentication of a provider
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, value := range t.headers {
+		if req.Header.Get(key) == "" {
+			req.Header.Set(key, os.ExpandEnv(value))
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// readTimeoutTransport fails response bodies that receive no data for the
+// timeout. Unlike a deadline for the whole request it lets streamed answers
+// run as long as they keep coming.
+type readTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *readTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = newTimeoutBody(resp.Body, t.timeout)
+	return resp, nil
+}
+
+// timeoutBody closes the body when a read waits longer than the timeout,
+// which makes the read fail with a timeout error
+type timeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newTimeoutBody(body io.ReadCloser, timeout time.Duration) *timeoutBody {
+	b := &timeoutBody{body: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.expired.Store(true)
+		b.body.Close()
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+	if err != nil && b.expired.Load() {
+		err = &timeoutError{b.timeout}
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
+
+// timeoutError is a net.Error, so it is reported as a network failure
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("no data received for %s", e.timeout)
+}
+
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
@@ -0,0 +1,158 @@
+// internal/httpclient/httpclient_test.go
+package httpclient
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+)
+
+func TestHeaders(t *testing.T) {
+	t.Setenv("GATEWAY_TOKEN", "secret")
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer server.Close()
+
+	client, err := New(config.HTTPConfig{Headers: map[string]string{
+		"x-gateway-token": "$GATEWAY_TOKEN",
+		"user-agent":      "replaced",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("User-Agent", "ask")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := header.Get("X-Gateway-Token"); got != "secret" {
+		t.Errorf("expected the expanded header, got %q", got)
+	}
+	if got := header.Get("User-Agent"); got != "ask" {
+		t.Errorf("expected the request header to win, got %q", got)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := New(config.HTTPConfig{Proxy: proxy.URL, NoProxy: "direct.invalid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("http://api.example.invalid/v1/models")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if proxied != "http://api.example.invalid/v1/models" {
+		t.Errorf("expected the request to go through the proxy, got %q", proxied)
+	}
+
+	// Hosts in no_proxy are dialed directly, which fails for .invalid
+	proxied = ""
+	if _, err := client.Get("http://direct.invalid/"); err == nil || proxied != "" {
+		t.Errorf("expected a direct connection for a no_proxy host")
+	}
+}
+
+func TestCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// The test certificate is not trusted by the system
+	client, err := New(config.HTTPConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("expected an untrusted certificate to fail")
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	client, err = New(config.HTTPConfig{CACert: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the CA bundle to be trusted: %v", err)
+	}
+	resp.Body.Close()
+
+	if err := os.WriteFile(path, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(config.HTTPConfig{CACert: path}); err == nil {
+		t.Error("expected an error for a bundle without certificates")
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := New(config.HTTPConfig{ReadTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if string(data) != "first chunk" {
+		t.Errorf("expected the data before the stall, got %q", data)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	body, changed := RedactBody([]byte(`{"access_token":"gho_secret","max_tokens":10}`))
+	if !changed || string(body) != `{"access_token":"[redacted]","max_tokens":10}` {
+		t.Errorf("expected the access token to be redacted, got %s", body)
+	}
+
+	stream := "data: {\"text\":\"hi\"}\n\ndata: {\"token\":\"secret\"}\n\ndata: [DONE]\n\n"
+	body, changed = RedactBody([]byte(stream))
+	if want := "data: {\"text\":\"hi\"}\n\ndata: {\"token\":\"[redacted]\"}\n\ndata: [DONE]\n\n"; !changed || string(body) != want {
+		t.Errorf("expected the token event to be redacted, got %q", body)
+	}
+
+	if body, changed := RedactBody([]byte(`{"usage":{"input_tokens":3}}`)); changed {
+		t.Errorf("expected a body without secrets to be unchanged, got %s", body)
+	}
+}
@@ -0,0 +1,128 @@
+// internal/httpclient/redact.go
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Redacted replaces secrets in requests that are shown or saved
+const Redacted = "[redacted]"
+
+// secretHeaderParts mark the headers whose values are never shown or saved
+var secretHeaderParts = []string{"authorization", "key", "token", "secret", "cookie"}
+
+// secretQueryParams are the URL parameters some APIs take keys in
+var secretQueryParams = []string{"key", "api_key", "token", "access_token"}
+
+// secretFields are the JSON fields tokens and keys are exchanged in, such
+// as the API token of the Copilot token exchange. Matched exactly, as fields
+// such as max_tokens are no secret.
+var secretFields = map[string]bool{
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"api_key":       true,
+	"apikey":        true,
+	"secret":        true,
+	"client_secret": true,
+	"password":      true,
+}
+
+// RedactHeader returns a copy of header with the values of API keys, tokens
+// and cookies replaced
+func RedactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for name := range header {
+		lower := strings.ToLower(name)
+		for _, part := range secretHeaderParts {
+			if strings.Contains(lower, part) {
+				header[name] = []string{Redacted}
+				break
+			}
+		}
+	}
+	return header
+}
+
+// RedactURL returns u with the values of key parameters replaced
+func RedactURL(u *url.URL) string {
+	redacted := *u
+	query := redacted.Query()
+	for _, name := range secretQueryParams {
+		if query.Has(name) {
+			query.Set(name, Redacted)
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// RedactBody returns body with the string values of secret JSON fields
+// replaced, and whether any were. The body is a JSON value or a stream of
+// server-sent events with JSON data. Other bodies are returned unchanged.
+func RedactBody(body []byte) ([]byte, bool) {
+	if redacted, changed := redactJSON(body); changed {
+		return redacted, true
+	}
+	if !bytes.Contains(body, []byte("data:")) {
+		return body, false
+	}
+
+	lines := bytes.SplitAfter(body, []byte("\n"))
+	changed := false
+	for i, line := range lines {
+		data, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			continue
+		}
+		trimmed := bytes.TrimSpace(data)
+		if redacted, ok := redactJSON(trimmed); ok {
+			lines[i] = bytes.Replace(line, trimmed, redacted, 1)
+			changed = true
+		}
+	}
+	if !changed {
+		return body, false
+	}
+	return bytes.Join(lines, nil), true
+}
+
+// redactJSON redacts a JSON value, re-encoding it only if a secret was found
+func redactJSON(data []byte) ([]byte, bool) {
+	var value interface{}
+	if json.Unmarshal(data, &value) != nil || !redactValue(value) {
+		return data, false
+	}
+	redacted, err := json.Marshal(value)
+	if err != nil {
+		return data, false
+	}
+	return redacted, true
+}
+
+func redactValue(value interface{}) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if _, ok := field.(string); ok && secretFields[strings.ToLower(name)] {
+				v[name] = Redacted
+				changed = true
+			} else if redactValue(field) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if redactValue(item) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,191 @@
+// internal/ledger/ledger.go
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/pkg/env"
+)
+
+// fileName is the ledger file inside the cache directory
+const fileName = "ledger.jsonl"
+
+// Entry is the record of one request
+type Entry struct {
+	Time         time.Time `json:"time"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	Command      string    `json:"command"`
+	Profile      string    `json:"profile,omitempty"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	// Cost is the estimated cost in USD, zero when the price is unknown
+	Cost float64 `json:"cost"`
+}
+
+// Ledger is an append-only file of JSON lines recording the spend per request
+type Ledger struct {
+	path string
+}
+
+// New returns the ledger stored in dir
+func New(dir string) *Ledger {
+	return &Ledger{path: filepath.Join(dir, fileName)}
+}
+
+// Default returns the ledger in the shell-ask cache directory
+func Default() (*Ledger, error) {
+	dir, err := env.GetCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return New(dir), nil
+}
+
+// Path returns the location of the ledger file
+func (l *Ledger) Path() string {
+	return l.path
+}
+
+// Record appends an entry to the ledger
+func (l *Ledger) Record(entry Entry) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Entries returns all recorded entries. A missing ledger has no entries and
+// lines that fail to decode are skipped.
+func (l *Ledger) Entries() ([]Entry, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Spent sums the cost of the entries since the given time that match
+func Spent(entries []Entry, since time.Time, match func(Entry) bool) float64 {
+	var total float64
+	for _, entry := range entries {
+		if !entry.Time.Before(since) && match(entry) {
+			total += entry.Cost
+		}
+	}
+	return total
+}
+
+// StartOfDay returns midnight of the day of t in its location
+func StartOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// StartOfMonth returns midnight of the first day of the month of t
+func StartOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// BudgetError reports a request that would exceed a spend budget
+type BudgetError struct {
+	// Scope names what the budget applies to, such as "provider openai"
+	Scope  string
+	Period string
+	Limit  float64
+	Spent  float64
+	// Estimate is the estimated cost of the refused request
+	Estimate float64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s budget of $%.2f for %s would be exceeded: $%.4f spent, this request is estimated at $%.4f (use --over-budget to send it anyway)",
+		e.Period, e.Limit, e.Scope, e.Spent, e.Estimate)
+}
+
+// budgetScope is a budget with the entries it applies to
+type budgetScope struct {
+	name   string
+	budget config.Budget
+	match  func(Entry) bool
+}
+
+// Check returns a *BudgetError when spending estimate more at now would
+// exceed the daily or monthly budget of the provider or of the profile
+func (l *Ledger) Check(budgets config.Budgets, provider, profile string, estimate float64, now time.Time) error {
+	var scopes []budgetScope
+	// viper lowercases map keys, so names are matched in lowercase
+	if budget, ok := budgets.Providers[strings.ToLower(provider)]; ok {
+		scopes = append(scopes, budgetScope{"provider " + provider, budget, func(e Entry) bool { return strings.EqualFold(e.Provider, provider) }})
+	}
+	if budget, ok := budgets.Profiles[strings.ToLower(profile)]; ok && profile != "" {
+		scopes = append(scopes, budgetScope{"profile " + profile, budget, func(e Entry) bool { return strings.EqualFold(e.Profile, profile) }})
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	entries, err := l.Entries()
+	if err != nil {
+		return fmt.Errorf("failed to read spend ledger: %w", err)
+	}
+
+	for _, scope := range scopes {
+		periods := []struct {
+			name  string
+			limit float64
+			since time.Time
+		}{
+			{"daily", scope.budget.Daily, StartOfDay(now)},
+			{"monthly", scope.budget.Monthly, StartOfMonth(now)},
+		}
+		for _, period := range periods {
+			if period.limit <= 0 {
+				continue
+			}
+			spent := Spent(entries, period.since, scope.match)
+			if spent+estimate > period.limit {
+				return &BudgetError{
+					Scope:    scope.name,
+					Period:   period.name,
+					Limit:    period.limit,
+					Spent:    spent,
+					Estimate: estimate,
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package ledger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+)
+
+func TestRecordAndEntries(t *testing.T) {
+	book := New(filepath.Join(t.TempDir(), "shell-ask"))
+
+	entries, err := book.Entries()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty ledger, got %v, %v", entries, err)
+	}
+
+	now := time.Now()
+	for _, cost := range []float64{0.5, 0.25} {
+		if err := book.Record(Entry{Time: now, Provider: "openai", Model: "gpt-4", Command: "ask", Cost: cost}); err != nil {
+			t.Fatalf("failed to record entry: %v", err)
+		}
+	}
+
+	entries, err = book.Entries()
+	if err != nil {
+		t.Fatalf("failed to read entries: %v", err)
+	}
+	if len(entries) != 2 || entries[1].Cost != 0.25 || entries[0].Model != "gpt-4" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	book := New(dir)
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	records := []Entry{
+		{Time: now.Add(-time.Hour), Provider: "openai", Profile: "alice", Cost: 0.9},
+		{Time: now.AddDate(0, 0, -3), Provider: "openai", Profile: "bob", Cost: 8},
+		{Time: now.AddDate(0, -1, 0), Provider: "openai", Cost: 100},
+	}
+	for _, entry := range records {
+		if err := book.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	budgets := config.Budgets{
+		Providers: map[string]config.Budget{"openai": {Daily: 1, Monthly: 10}},
+		Profiles:  map[string]config.Budget{"alice": {Daily: 0.5}},
+	}
+
+	if err := book.Check(budgets, "openai", "", 0.05, now); err != nil {
+		t.Errorf("expected request within budget, got %v", err)
+	}
+
+	var budgetErr *BudgetError
+	err := book.Check(budgets, "openai", "", 0.2, now)
+	if !errors.As(err, &budgetErr) || budgetErr.Period != "daily" || budgetErr.Spent != 0.9 {
+		t.Errorf("expected daily provider budget error, got %v", err)
+	}
+
+	// Last month's spend does not count against this month
+	err = book.Check(config.Budgets{Providers: map[string]config.Budget{"openai": {Monthly: 9}}}, "openai", "", 0.5, now)
+	if !errors.As(err, &budgetErr) || budgetErr.Period != "monthly" || budgetErr.Spent != 8.9 {
+		t.Errorf("expected monthly provider budget error, got %v", err)
+	}
+
+	err = book.Check(budgets, "anthropic", "Alice", 0, now)
+	if !errors.As(err, &budgetErr) || budgetErr.Scope != "profile Alice" {
+		t.Errorf("expected profile budget error, got %v", err)
+	}
+
+	if err := book.Check(budgets, "anthropic", "bob", 100, now); err != nil {
+		t.Errorf("expected no error without a matching budget, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "ledger.jsonl")); err != nil {
+		t.Errorf("expected ledger file in the directory: %v", err)
+	}
+}
@@ -16,6 +16,10 @@ type ModelInfo struct {
 	Name        string
 	Description string
 	Family      string
+	// InputPrice and OutputPrice are in USD per million tokens. Zero means
+	// the price is unknown.
+	InputPrice  float64
+	OutputPrice float64
 }
 
 type Models struct {
@@ -24,25 +28,27 @@ type Models struct {
 
 var ModelMap = map[string][]ModelInfo{
 	"gpt": {
-		{ID: "gpt-3.5-turbo"},
-		{ID: "gpt-4-turbo"},
-		{ID: "gpt-4"},
-		{ID: "gpt-4-32k"},
+		{ID: "gpt-3.5-turbo", InputPrice: 0.5, OutputPrice: 1.5},
+		{ID: "gpt-4-turbo", InputPrice: 10, OutputPrice: 30},
+		{ID: "gpt-4", InputPrice: 30, OutputPrice: 60},
+		{ID: "gpt-4-32k", InputPrice: 60, OutputPrice: 120},
+		{ID: "gpt-4o", InputPrice: 2.5, OutputPrice: 10},
+		{ID: "gpt-4o-mini", InputPrice: 0.15, OutputPrice: 0.6},
 	},
 	"claude": {
-		{ID: "claude-3-haiku", RealID: "claude-3-haiku-20240307"},
-		{ID: "claude-3-sonnet", RealID: "claude-3-sonnet-20240229"},
-		{ID: "claude-3-opus", RealID: "claude-3-opus-20240229"},
+		{ID: "claude-3-haiku", RealID: "claude-3-haiku-20240307", InputPrice: 0.25, OutputPrice: 1.25},
+		{ID: "claude-3-sonnet", RealID: "claude-3-sonnet-20240229", InputPrice: 3, OutputPrice: 15},
+		{ID: "claude-3-opus", RealID: "claude-3-opus-20240229", InputPrice: 15, OutputPrice: 75},
 	},
 	"gemini": {
-		{ID: "gemini-pro"},
-		{ID: "gemini-1.5-pro", RealID: "gemini-1.5-pro-latest"},
-		{ID: "gemini-1.5-flash", RealID: "gemini-1.5-flash-latest"},
+		{ID: "gemini-pro", InputPrice: 0.5, OutputPrice: 1.5},
+		{ID: "gemini-1.5-pro", RealID: "gemini-1.5-pro-latest", InputPrice: 1.25, OutputPrice: 5},
+		{ID: "gemini-1.5-flash", RealID: "gemini-1.5-flash-latest", InputPrice: 0.075, OutputPrice: 0.3},
 	},
 	"groq": {
-		{ID: "groq-llama3", RealID: "llama3-70b-8192"},
-		{ID: "groq-mixtral", RealID: "mixtral-8x7b-32768"},
-		{ID: "groq-gemma", RealID: "gemma-7b-it"},
+		{ID: "groq-llama3", RealID: "llama3-70b-8192", InputPrice: 0.59, OutputPrice: 0.79},
+		{ID: "groq-mixtral", RealID: "mixtral-8x7b-32768", InputPrice: 0.24, OutputPrice: 0.24},
+		{ID: "groq-gemma", RealID: "gemma-7b-it", InputPrice: 0.07, OutputPrice: 0.07},
 	},
 	"copilot": {
 		{ID: "copilot-chat", Description: "GitHub Copilot Chat"},
@@ -80,6 +86,90 @@ func SelectModel(input string) string {
 	return "gpt-4o-mini" // default model
 }
 
+// RealModelID returns the API model name for a known model alias, or the
+// input unchanged if it is not an alias
+func RealModelID(id string) string {
+	for _, models := range ModelMap {
+		for _, model := range models {
+			if model.ID == id && model.RealID != "" {
+				return model.RealID
+			}
+		}
+	}
+	return id
+}
+
+// GetPricing returns the input and output price per million tokens of a
+// known model, matched by alias or API name with any provider/ prefix
+// removed
+func GetPricing(id string) (input, output float64, ok bool) {
+	if i := strings.Index(id, "/"); i >= 0 {
+		id = id[i+1:]
+	}
+	for _, models := range ModelMap {
+		for _, model := range models {
+			if model.ID != id && model.RealID != id {
+				continue
+			}
+			if model.InputPrice == 0 && model.OutputPrice == 0 {
+				return 0, 0, false
+			}
+			return model.InputPrice, model.OutputPrice, true
+		}
+	}
+	return 0, 0, false
+}
+
+// EstimateTokens roughly estimates the token count of text, assuming about
+// four characters per token as is typical for English text and code
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// Name fragments of models that do not accept images, checked before the
+// ones of vision models as names such as gpt-4-32k contain both
+var textOnlyModels = []string{"gpt-3.5", "gpt-4-32k", "o1-mini", "o3-mini", "gemini-pro", "gemini-1.0"}
+
+// visionModels are name fragments of models that accept images
+var visionModels = []string{
+	"gpt-4o", "gpt-4-turbo", "gpt-4.1", "gpt-4.5", "gpt-5", "chatgpt-4o",
+	"claude-3", "claude-sonnet-4", "claude-opus-4", "claude-haiku-4",
+	"gemini", "llava", "moondream", "minicpm-v", "-vl", "gemma3", "pixtral",
+}
+
+// SupportsVision reports whether a model accepts images. known is false for
+// models it knows nothing about, such as most models of custom endpoints.
+func SupportsVision(id string) (supported, known bool) {
+	if i := strings.Index(id, "/"); i >= 0 {
+		id = id[i+1:]
+	}
+	id = strings.ToLower(RealModelID(id))
+
+	if strings.Contains(id, "vision") {
+		return true, true
+	}
+	for _, fragment := range textOnlyModels {
+		if strings.Contains(id, fragment) {
+			return false, true
+		}
+	}
+	for _, fragment := range visionModels {
+		if strings.Contains(id, fragment) {
+			return true, true
+		}
+	}
+	// The reasoning models o1, o3 and o4 and their dated versions
+	for _, prefix := range []string{"o1", "o3", "o4"} {
+		if id == prefix || strings.HasPrefix(id, prefix+"-") {
+			return true, true
+		}
+	}
+	if id == "gpt-4" || strings.HasPrefix(id, "gpt-4-0") {
+		return false, true
+	}
+	return false, false
+}
+
 func ValidateOllamaModel(name string) bool {
 	// Basic validation for Ollama model format (name:tag)
 	parts := strings.Split(name, ":")
@@ -26,3 +26,53 @@ func TestModelInfo(t *testing.T) {
 	}
 }
 
+func TestGetPricing(t *testing.T) {
+	for _, id := range []string{"claude-3-haiku", "claude-3-haiku-20240307", "anthropic/claude-3-haiku"} {
+		input, output, ok := GetPricing(id)
+		if !ok || input != 0.25 || output != 1.25 {
+			t.Errorf("GetPricing(%q) = %v, %v, %v", id, input, output, ok)
+		}
+	}
+
+	if _, _, ok := GetPricing("copilot-chat"); ok {
+		t.Error("expected no pricing for a model without prices")
+	}
+	if _, _, ok := GetPricing("qwen2.5:7b"); ok {
+		t.Error("expected no pricing for an unknown model")
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if got := EstimateTokens("abcdefgh"); got != 2 {
+		t.Errorf("expected 2 tokens, got %d", got)
+	}
+	if got := EstimateTokens(""); got != 0 {
+		t.Errorf("expected 0 tokens, got %d", got)
+	}
+}
+
+func TestSupportsVision(t *testing.T) {
+	tests := []struct {
+		id               string
+		supported, known bool
+	}{
+		{"gpt-4o-mini", true, true},
+		{"openai/gpt-4-turbo", true, true},
+		{"gpt-4", false, true},
+		{"gpt-3.5-turbo", false, true},
+		{"o1-mini", false, true},
+		{"o1", true, true},
+		{"claude-3-haiku", true, true},
+		{"gemini-pro", false, true},
+		{"gemini-pro-vision", true, true},
+		{"gemini-1.5-flash", true, true},
+		{"llava:13b", true, true},
+		{"local/qwen2.5", false, false},
+	}
+	for _, tt := range tests {
+		supported, known := SupportsVision(tt.id)
+		if supported != tt.supported || known != tt.known {
+			t.Errorf("SupportsVision(%s): expected %v, %v, got %v, %v", tt.id, tt.supported, tt.known, supported, known)
+		}
+	}
+}
@@ -3,13 +3,45 @@ package providers
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"io"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/pkg/chat"
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
 )
 
+// defaultAnthropicMaxTokens is used when no max tokens are configured, as
+// the Anthropic API requires the field
+const defaultAnthropicMaxTokens = 4096
+
+func init() {
+	Register(Registration{
+		Name:     "anthropic",
+		Prefixes: []string{"anthropic", "claude"},
+		Credential: &Credential{
+			EnvVar:     "ANTHROPIC_API_KEY",
+			ConfigKey:  "anthropic_api_key",
+			FromConfig: func(cfg *config.Config) string { return cfg.AnthropicKey },
+		},
+		New: func(cfg *config.Config, apiKey, model string) (Provider, error) {
+			client, err := NewHTTPClient(cfg)
+			if err != nil {
+				return nil, err
+			}
+			return NewAnthropicProvider(apiKey, model,
+				option.WithHTTPClient(client),
+				option.WithMaxRetries(0),
+			), nil
+		},
+	})
+}
+
 type AnthropicProvider struct {
 	client *anthropic.Client
 	model  string
@@ -17,9 +49,10 @@ type AnthropicProvider struct {
 
 // NewAnthropicProvider creates a new Anthropic provider
 // model should be one of: claude-3-opus-20240229, claude-3-sonnet-20240229, claude-3-haiku-20240307
-func NewAnthropicProvider(apiKey, model string) *AnthropicProvider {
+// opts are passed on to the SDK client.
+func NewAnthropicProvider(apiKey, model string, opts ...option.RequestOption) *AnthropicProvider {
 	client := anthropic.NewClient(
-		option.WithAPIKey(apiKey),
+		append([]option.RequestOption{option.WithAPIKey(apiKey)}, opts...)...,
 	)
 	return &AnthropicProvider{
 		client: client,
@@ -27,34 +60,153 @@ func NewAnthropicProvider(apiKey, model string) *AnthropicProvider {
 	}
 }
 
-func (p *AnthropicProvider) Complete(ctx context.Context, prompt string, stream bool) (io.ReadCloser, error) {
-	req := anthropic.MessageNewParams{
-		MaxTokens: anthropic.Int(1024),
-		Messages: anthropic.F([]anthropic.MessageParam{
-			anthropic.NewUserMessage(anthropic.NewTextBlock("You are a helpful AI assistant.\n\n" + prompt)),
-		}),
-		Model:         anthropic.F(p.model),
-		StopSequences: anthropic.F([]string{"```\n"}),
+// anthropicMessages converts the request turns into Anthropic messages. Tool
+// results are user messages, and the results of one turn of tool calls must
+// be sent together in a single message.
+func anthropicMessages(req *Request) []anthropic.MessageParam {
+	var messages []anthropic.MessageParam
+	var results []anthropic.ContentBlockParamUnion
+	for _, msg := range req.Turns() {
+		if msg.Role == chat.RoleTool {
+			results = append(results, anthropic.NewToolResultBlock(msg.ToolCallID, msg.Content, false))
+			continue
+		}
+		if len(results) > 0 {
+			messages = append(messages, anthropic.NewUserMessage(results...))
+			results = nil
+		}
+
+		switch msg.Role {
+		case chat.RoleAssistant:
+			messages = append(messages, anthropic.NewAssistantMessage(anthropicAssistantBlocks(msg)...))
+		default:
+			// Images go first, as Anthropic recommends
+			var blocks []anthropic.ContentBlockParamUnion
+			for _, image := range msg.Images {
+				blocks = append(blocks, anthropic.NewImageBlockBase64(image.MIMEType, base64.StdEncoding.EncodeToString(image.Data)))
+			}
+			blocks = append(blocks, anthropic.NewTextBlock(msg.Content))
+			messages = append(messages, anthropic.NewUserMessage(blocks...))
+		}
 	}
+	if len(results) > 0 {
+		messages = append(messages, anthropic.NewUserMessage(results...))
+	}
+	return messages
+}
 
-	if stream {
-		stream := p.client.Messages.NewStreaming(ctx, req)
+// anthropicAssistantBlocks converts an assistant message and its tool calls
+// into content blocks
+func anthropicAssistantBlocks(msg chat.Message) []anthropic.ContentBlockParamUnion {
+	var blocks []anthropic.ContentBlockParamUnion
+	if msg.Content != "" || len(msg.ToolCalls) == 0 {
+		blocks = append(blocks, anthropic.NewTextBlock(msg.Content))
+	}
+	for _, call := range msg.ToolCalls {
+		input := json.RawMessage(call.Arguments)
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		}
+		blocks = append(blocks, anthropic.NewToolUseBlockParam(call.ID, call.Name, input))
+	}
+	return blocks
+}
+
+// anthropicTools converts tools into Anthropic tool definitions
+func anthropicTools(tools []Tool) []anthropic.ToolParam {
+	params := make([]anthropic.ToolParam, 0, len(tools))
+	for _, tool := range tools {
+		params = append(params, anthropic.ToolParam{
+			Name:        anthropic.F(tool.Name),
+			Description: anthropic.F(tool.Description),
+			InputSchema: anthropic.F[interface{}](tool.schema()),
+		})
+	}
+	return params
+}
+
+// anthropicCachedTokens reads the prompt cache hits, which the SDK version
+// in use only exposes as an extra JSON field
+func anthropicCachedTokens(usage anthropic.Usage) int {
+	cached, _ := strconv.Atoi(usage.JSON.ExtraFields["cache_read_input_tokens"].Raw())
+	return cached
+}
+
+// params builds the message parameters for a request
+func (p *AnthropicProvider) params(req *Request) anthropic.MessageNewParams {
+	maxTokens := defaultAnthropicMaxTokens
+	if req.Options.MaxTokens != nil {
+		maxTokens = *req.Options.MaxTokens
+	}
+
+	params := anthropic.MessageNewParams{
+		MaxTokens: anthropic.Int(int64(maxTokens)),
+		Messages:  anthropic.F(anthropicMessages(req)),
+		Model:     anthropic.F(p.model),
+	}
+	if system := req.SystemPrompt(); system != "" {
+		params.System = anthropic.F([]anthropic.TextBlockParam{anthropic.NewTextBlock(system)})
+	}
+	if req.Options.Temperature != nil {
+		params.Temperature = anthropic.F(*req.Options.Temperature)
+	}
+	if req.Options.TopP != nil {
+		params.TopP = anthropic.F(*req.Options.TopP)
+	}
+	if len(req.Options.Stop) > 0 {
+		params.StopSequences = anthropic.F(req.Options.Stop)
+	}
+	if len(req.Tools) > 0 {
+		params.Tools = anthropic.F(anthropicTools(req.Tools))
+	}
+	return params
+}
+
+func (p *AnthropicProvider) Complete(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	if err := checkVision(p.model, req); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	params := p.params(req)
+
+	if req.Stream {
+		stream := p.client.Messages.NewStreaming(ctx, params)
 
 		reader, writer := io.Pipe()
+		resp := newResponse(reader, p.model, start)
 
 		go func() {
+			defer closeOnCancel(ctx, writer)()
 			defer writer.Close()
+			// A request that failed before the stream opened leaves nothing
+			// to close
+			if stream.Err() == nil {
+				defer stream.Close()
+			}
 			for stream.Next() {
 				event := stream.Current()
 
-				switch delta := event.Delta.(type) {
-				case anthropic.ContentBlockDeltaEventDelta:
-					if delta.Text != "" {
-						writer.Write([]byte(delta.Text))
-					}
-				case anthropic.MessageDeltaEventDelta:
-					if delta.StopSequence != "" {
-						writer.Write([]byte(delta.StopSequence))
+				switch event.Type {
+				case anthropic.MessageStreamEventTypeMessageStart:
+					resp.update(func(u *Usage) {
+						u.Model = string(event.Message.Model)
+						u.InputTokens = int(event.Message.Usage.InputTokens)
+						u.CachedTokens = anthropicCachedTokens(event.Message.Usage)
+					})
+				case anthropic.MessageStreamEventTypeMessageDelta:
+					resp.update(func(u *Usage) {
+						u.OutputTokens = int(event.Usage.OutputTokens)
+						if delta, ok := event.Delta.(anthropic.MessageDeltaEventDelta); ok {
+							u.FinishReason = string(delta.StopReason)
+						}
+					})
+				}
+
+				if delta, ok := event.Delta.(anthropic.ContentBlockDeltaEventDelta); ok && delta.Text != "" {
+					// A failed write means the reader was closed, so stop
+					// consuming
+					if _, err := writer.Write([]byte(delta.Text)); err != nil {
+						return
 					}
 				}
 			}
@@ -64,18 +216,65 @@ func (p *AnthropicProvider) Complete(ctx context.Context, prompt string, stream
 			}
 		}()
 
-		return reader, nil
+		return resp, nil
 	}
 
-	resp, err := p.client.Messages.New(ctx, req)
+	message, err := p.client.Messages.New(ctx, params)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(resp.Content) > 0 {
-		return io.NopCloser(strings.NewReader(resp.Content[0].Text)), nil
+	var text string
+	if len(message.Content) > 0 {
+		text = message.Content[0].Text
+	}
+
+	resp := newResponse(io.NopCloser(strings.NewReader(text)), p.model, start)
+	resp.update(func(u *Usage) {
+		u.Model = string(message.Model)
+		u.InputTokens = int(message.Usage.InputTokens)
+		u.OutputTokens = int(message.Usage.OutputTokens)
+		u.CachedTokens = anthropicCachedTokens(message.Usage)
+		u.FinishReason = string(message.StopReason)
+	})
+	return resp, nil
+}
+
+func (p *AnthropicProvider) CompleteWithTools(ctx context.Context, req *Request) (*ToolResponse, error) {
+	if err := checkVision(p.model, req); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	message, err := p.client.Messages.New(ctx, p.params(req))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ToolResponse{
+		Usage: Usage{
+			Model:        string(message.Model),
+			InputTokens:  int(message.Usage.InputTokens),
+			OutputTokens: int(message.Usage.OutputTokens),
+			CachedTokens: anthropicCachedTokens(message.Usage),
+			FinishReason: string(message.StopReason),
+			Latency:      time.Since(start),
+		},
+	}
+	var text strings.Builder
+	for _, block := range message.Content {
+		switch block.Type {
+		case anthropic.ContentBlockTypeText:
+			text.WriteString(block.Text)
+		case anthropic.ContentBlockTypeToolUse:
+			resp.ToolCalls = append(resp.ToolCalls, chat.ToolCall{
+				ID:        block.ID,
+				Name:      block.Name,
+				Arguments: string(block.Input),
+			})
+		}
 	}
-	return io.NopCloser(strings.NewReader("")), nil
+	resp.Content = text.String()
+	return resp, nil
 }
 
 func (p *AnthropicProvider) Name() string {
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/acazau/shell-ask-go/internal/config"
 )
 
 type Client struct {
@@ -15,14 +17,35 @@ type Client struct {
 	client  *http.Client
 }
 
+// NewClient returns a client for the API at baseURL on the shared transport
+// with the default HTTP settings, retrying rate-limited and transient
+// failures like the providers do
 func NewClient(apiKey string, baseURL string) *Client {
+	// The default HTTP settings load no certificates, so they cannot fail
+	client, _ := NewHTTPClient(&config.Config{Retries: config.DefaultRetries})
 	return &Client{
 		apiKey:  apiKey,
 		baseURL: baseURL,
-		client:  &http.Client{},
+		client:  client,
 	}
 }
 
+// NewClientWithConfig returns a client for the API at baseURL using the
+// configured transport and retries
+func NewClientWithConfig(cfg *config.Config, apiKey string, baseURL string) (*Client, error) {
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{apiKey: apiKey, baseURL: baseURL, client: client}, nil
+}
+
+// SetHTTPClient replaces the HTTP client, such as with the one of
+// NewHTTPClient for the configured transport and retries
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
 func (c *Client) Post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
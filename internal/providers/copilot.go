@@ -9,12 +9,42 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/copilot"
+	"github.com/acazau/shell-ask-go/pkg/chat"
 )
 
 const (
 	copilotCompletionAPI = "https://api.githubcopilot.com/chat/completions"
 )
 
+func init() {
+	Register(Registration{
+		Name:     "copilot",
+		Prefixes: []string{"copilot-"},
+		// Copilot authenticates with the token saved by copilot-login
+		New: func(cfg *config.Config, apiKey, model string) (Provider, error) {
+			client, err := NewHTTPClient(cfg)
+			if err != nil {
+				return nil, err
+			}
+			copilotClient := copilot.New(config.GetConfigDir(), client)
+			token, err := copilotClient.GetAPIToken()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get Copilot token: %w", err)
+			}
+			provider, err := NewCopilotProvider(token, model)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize Copilot provider: %w", err)
+			}
+			provider.client = client
+			return provider, nil
+		},
+	})
+}
+
 type CopilotProvider struct {
 	client *http.Client
 	token  string
@@ -57,37 +87,58 @@ type copilotRequest struct {
 	Model       string           `json:"model"`
 	N           int              `json:"n"`
 	Stream      bool             `json:"stream"`
-	Temperature float32          `json:"temperature"`
-	TopP        int              `json:"top_p"`
+	Temperature float64          `json:"temperature"`
+	TopP        float64          `json:"top_p"`
 	Messages    []copilotMessage `json:"messages"`
 	MaxTokens   int              `json:"max_tokens"`
+	Stop        []string         `json:"stop,omitempty"`
+	Seed        *int             `json:"seed,omitempty"`
 }
 
+// Defaults used by the Copilot editor integrations
+const (
+	copilotDefaultTemperature = 0.1
+	copilotDefaultTopP        = 1
+	copilotDefaultMaxTokens   = 8192
+)
+
 type copilotMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
-type copilotResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
-}
+func (p *CopilotProvider) Complete(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	if err := rejectImages("copilot", req); err != nil {
+		return nil, err
+	}
+	var messages []copilotMessage
+	if system := req.SystemPrompt(); system != "" {
+		messages = append(messages, copilotMessage{Role: chat.RoleSystem, Content: system})
+	}
+	for _, msg := range req.Turns() {
+		messages = append(messages, copilotMessage{Role: msg.Role, Content: msg.Content})
+	}
 
-func (p *CopilotProvider) Complete(ctx context.Context, prompt string, stream bool) (io.ReadCloser, error) {
 	reqBody := copilotRequest{
 		Intent:      true,
 		Model:       p.model,
 		N:           1,
-		Stream:      stream,
-		Temperature: 0.1,
-		TopP:        1,
-		MaxTokens:   8192,
-		Messages: []copilotMessage{
-			{Role: "user", Content: prompt},
-		},
+		Stream:      req.Stream,
+		Temperature: copilotDefaultTemperature,
+		TopP:        copilotDefaultTopP,
+		MaxTokens:   copilotDefaultMaxTokens,
+		Messages:    messages,
+		Stop:        req.Options.Stop,
+		Seed:        req.Options.Seed,
+	}
+	if req.Options.Temperature != nil {
+		reqBody.Temperature = *req.Options.Temperature
+	}
+	if req.Options.TopP != nil {
+		reqBody.TopP = *req.Options.TopP
+	}
+	if req.Options.MaxTokens != nil {
+		reqBody.MaxTokens = *req.Options.MaxTokens
 	}
 
 	body, err := json.Marshal(reqBody)
@@ -95,9 +146,8 @@ func (p *CopilotProvider) Complete(ctx context.Context, prompt string, stream bo
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	fmt.Printf("Debug - Request Body: %s\n", string(body))
-
-	req, err := http.NewRequestWithContext(
+	start := time.Now()
+	httpReq, err := http.NewRequestWithContext(
 		ctx,
 		"POST",
 		copilotCompletionAPI,
@@ -108,19 +158,18 @@ func (p *CopilotProvider) Complete(ctx context.Context, prompt string, stream bo
 	}
 
 	// Add required headers
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.token))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("OpenAI-Intent", "conversation-panel")
-	req.Header.Set("OpenAI-Organization", "github-copilot")
-	req.Header.Set("Editor-Version", "vscode/1.88.0")
-	req.Header.Set("Editor-Plugin-Version", "copilot-chat/0.14.2024032901")
-	req.Header.Set("User-Agent", "GitHubCopilotChat/0.14.2024032901")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Accept-Encoding", "gzip,deflate,br")
-	req.Header.Set("X-GitHub-Api-Version", "2023-07-07")
-	req.Header.Set("Copilot-Integration-Id", "vscode-chat")
-
-	resp, err := p.client.Do(req)
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.token))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("OpenAI-Intent", "conversation-panel")
+	httpReq.Header.Set("OpenAI-Organization", "github-copilot")
+	httpReq.Header.Set("Editor-Version", "vscode/1.88.0")
+	httpReq.Header.Set("Editor-Plugin-Version", "copilot-chat/0.14.2024032901")
+	httpReq.Header.Set("User-Agent", "GitHubCopilotChat/0.14.2024032901")
+	httpReq.Header.Set("Accept", "*/*")
+	httpReq.Header.Set("X-GitHub-Api-Version", "2023-07-07")
+	httpReq.Header.Set("Copilot-Integration-Id", "vscode-chat")
+
+	resp, err := p.client.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
@@ -128,25 +177,14 @@ func (p *CopilotProvider) Complete(ctx context.Context, prompt string, stream bo
 	if resp.StatusCode != http.StatusOK {
 		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("copilot API error: %s - %s", resp.Status, string(body))
+		return nil, &HTTPError{Provider: "copilot", StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
 	}
 
-	if !stream {
-		var response copilotResponse
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			resp.Body.Close()
-			return nil, fmt.Errorf("failed to decode response: %w", err)
-		}
-
-		if len(response.Choices) == 0 {
-			resp.Body.Close()
-			return nil, fmt.Errorf("no completion choices returned")
-		}
-
-		return io.NopCloser(strings.NewReader(response.Choices[0].Message.Content)), nil
+	if !req.Stream {
+		return decodeChatCompletion(resp.Body, p.model, start)
 	}
 
-	return resp.Body, nil
+	return newSSEReader(resp.Body, p.model, start), nil
 }
 
 func (p *CopilotProvider) Name() string {
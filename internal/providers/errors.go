@@ -0,0 +1,285 @@
+// internal/providers/errors.go
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go"
+	"google.golang.org/api/googleapi"
+)
+
+// HTTPError is a failed response from a provider API called without an SDK
+type HTTPError struct {
+	Provider   string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s API error: %s", e.Provider, e.Status)
+	}
+	return fmt.Sprintf("%s API error: %s - %s", e.Provider, e.Status, e.Body)
+}
+
+// statusCode returns the HTTP status of a failed API call, whichever SDK
+// reported it
+func statusCode(err error) (int, bool) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode, true
+	}
+	var openAIErr *openai.Error
+	if errors.As(err, &openAIErr) {
+		return openAIErr.StatusCode, true
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode, true
+	}
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code, true
+	}
+	var coded interface{ HTTPCode() int }
+	if errors.As(err, &coded) && coded.HTTPCode() > 0 {
+		return coded.HTTPCode(), true
+	}
+	var ollamaErr api.StatusError
+	if errors.As(err, &ollamaErr) {
+		return ollamaErr.StatusCode, true
+	}
+	return 0, false
+}
+
+// ErrorKind classifies why a provider request failed
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindMissingKey
+	KindInvalidKey
+	KindRateLimited
+	KindContextTooLong
+	KindContentFiltered
+	KindNetwork
+	KindModelNotFound
+	KindServer
+)
+
+var kindNames = map[ErrorKind]string{
+	KindUnknown:         "request failed",
+	KindMissingKey:      "missing API key",
+	KindInvalidKey:      "invalid API key",
+	KindRateLimited:     "rate limited",
+	KindContextTooLong:  "context too long",
+	KindContentFiltered: "content filtered",
+	KindNetwork:         "network error",
+	KindModelNotFound:   "model not found",
+	KindServer:          "server error",
+}
+
+func (k ErrorKind) String() string {
+	return kindNames[k]
+}
+
+// Exit codes of the ask command per error kind. Scripts branch on them, so
+// existing codes must never change.
+var exitCodes = map[ErrorKind]int{
+	KindUnknown:         1,
+	KindMissingKey:      3,
+	KindInvalidKey:      4,
+	KindRateLimited:     5,
+	KindContextTooLong:  6,
+	KindContentFiltered: 7,
+	KindNetwork:         8,
+	KindModelNotFound:   9,
+	KindServer:          10,
+}
+
+// ExitCode returns the process exit status for err: the code of its kind
+// for a classified provider error, 1 for any other error
+func ExitCode(err error) int {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return exitCodes[providerErr.Kind]
+	}
+	return 1
+}
+
+// Error is a classified provider failure with a hint on how to fix it
+type Error struct {
+	Kind     ErrorKind
+	Provider string
+	// Hint tells the user what to do about the error
+	Hint string
+	// Err is the underlying error, nil when there is nothing more to say
+	Err error
+}
+
+func newError(kind ErrorKind, provider string, err error) *Error {
+	return &Error{Kind: kind, Provider: provider, Hint: errorHint(kind, provider), Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.String()
+	if e.Provider != "" {
+		msg = e.Provider + ": " + msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError wraps a failure of a request to provider into an *Error.
+// Errors that are already classified, that cannot be classified or that
+// come from a cancelled request are returned unchanged.
+func ClassifyError(provider string, err error) error {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return err
+	}
+	kind := errorKind(err)
+	if kind == KindUnknown {
+		return err
+	}
+	return newError(kind, provider, err)
+}
+
+// Messages of invalid requests telling that the prompt exceeds the context
+// window or was refused by a content filter, as the status alone is a 400
+var (
+	contextTooLongMarkers = []string{
+		"context_length_exceeded",
+		"maximum context length",
+		"context window",
+		"prompt is too long",
+		"input is too long",
+		"too many tokens",
+		"exceeds the maximum number of tokens",
+	}
+	contentFilteredMarkers = []string{
+		"content_filter",
+		"content_policy",
+		"content management policy",
+	}
+)
+
+func errorKind(err error) ErrorKind {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr.Kind
+	}
+
+	code, hasCode := statusCode(err)
+	if !hasCode || code == http.StatusBadRequest || code == http.StatusRequestEntityTooLarge {
+		msg := strings.ToLower(err.Error())
+		switch {
+		case containsAny(msg, contextTooLongMarkers):
+			return KindContextTooLong
+		case containsAny(msg, contentFilteredMarkers):
+			return KindContentFiltered
+		}
+	}
+
+	if hasCode {
+		switch {
+		case code == http.StatusUnauthorized, code == http.StatusForbidden:
+			return KindInvalidKey
+		case code == http.StatusTooManyRequests:
+			return KindRateLimited
+		case code == http.StatusNotFound:
+			return KindModelNotFound
+		case code == http.StatusRequestEntityTooLarge:
+			return KindContextTooLong
+		case code == http.StatusRequestTimeout:
+			return KindNetwork
+		case code >= 500:
+			return KindServer
+		}
+		return KindUnknown
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return KindNetwork
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return KindNetwork
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) || isDialError(err) {
+		return KindNetwork
+	}
+	return KindUnknown
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorHint tells the user how to fix an error of kind from provider
+func errorHint(kind ErrorKind, provider string) string {
+	switch kind {
+	case KindMissingKey, KindInvalidKey:
+		if registration, ok := Lookup(provider); ok && registration.Credential != nil {
+			return registration.Credential.Hint()
+		}
+		if provider == "copilot" {
+			return "run `ask copilot-login`"
+		}
+		return "check the api_key of the endpoint in the config file"
+	case KindRateLimited:
+		return "wait a moment, raise --retries or configure a fallback chain"
+	case KindContextTooLong:
+		return "shorten the prompt or the piped input, or use a model with a larger context window"
+	case KindContentFiltered:
+		if provider == "gemini" {
+			return "rephrase the prompt or relax gemini_safety_settings in the config file"
+		}
+		return "rephrase the prompt"
+	case KindNetwork:
+		return "check your network connection and proxy settings"
+	case KindModelNotFound:
+		if provider == "ollama" {
+			return "pull the model with `ollama pull` or run `ask list` to see the available models"
+		}
+		return "run `ask list` to see the available models"
+	case KindServer:
+		return "try again later or configure a fallback chain"
+	}
+	return ""
+}
+
+// isUnavailable reports whether err means the model could not answer right
+// now or with these credentials: an auth failure, a rate limit, a timeout, a
+// server error or an unreachable server. Other errors, such as an invalid
+// request, would fail the same way with any model.
+func isUnavailable(err error) bool {
+	switch errorKind(err) {
+	case KindInvalidKey, KindRateLimited, KindNetwork, KindServer:
+		return true
+	}
+	return false
+}
@@ -7,7 +7,7 @@ import (
 	"strings"
 
 	"github.com/acazau/shell-ask-go/internal/config"
-	"github.com/acazau/shell-ask-go/internal/copilot"
+	"github.com/acazau/shell-ask-go/internal/models"
 )
 
 // parseModelID splits a model ID into provider and model parts
@@ -20,63 +20,86 @@ func parseModelID(modelID string) (provider, model string) {
 	return "", modelID
 }
 
+// InitializeProvider loads the user configuration and creates the provider
+// for the given model ID
 func InitializeProvider(modelID string) (Provider, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return InitializeProviderWithConfig(cfg, modelID)
+}
+
+// InitializeProviderWithConfig creates the provider for the given model ID
+// using an already loaded configuration. An ID naming a configured fallback
+// chain returns a provider trying the models of the chain in order.
+func InitializeProviderWithConfig(cfg *config.Config, modelID string) (Provider, error) {
+	if chain, ok := cfg.FallbackChain(modelID); ok {
+		return newFallbackProvider(cfg, chain)
+	}
+	return initializeModel(cfg, modelID)
+}
+
+// initializeModel creates the provider for a single model ID
+func initializeModel(cfg *config.Config, modelID string) (Provider, error) {
+	registration, model, err := Resolve(cfg, modelID)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKey string
+	if registration.Credential != nil {
+		apiKey = registration.Credential.Value(cfg)
+		if apiKey == "" {
+			return nil, newError(KindMissingKey, registration.Name, nil)
+		}
+	}
+
+	return registration.New(cfg, apiKey, model)
+}
+
+// Resolve returns the provider registration and the model name to send to
+// it for a model ID. IDs of the form provider/model select the provider (or a
+// configured OpenAI-compatible endpoint) directly, other IDs are matched
+// against the registered model prefixes. Known aliases such as
+// claude-3-haiku are expanded to the real model name.
+func Resolve(cfg *config.Config, modelID string) (Registration, string, error) {
 	providerPrefix, modelName := parseModelID(modelID)
-	model := modelName // For Copilot, we want to keep the original model name
 
-	// If provider is explicitly specified, use it
 	if providerPrefix != "" {
-		switch providerPrefix {
-		case "openai":
-			return NewOpenAIProvider(os.Getenv("OPENAI_API_KEY"), model)
-		case "anthropic":
-			return NewAnthropicProvider(os.Getenv("ANTHROPIC_API_KEY"), model), nil
-		case "gemini":
-			return NewGeminiProvider(os.Getenv("GOOGLE_API_KEY"), model)
-		case "groq":
-			return NewGroqProvider(os.Getenv("GROQ_API_KEY"), model), nil
-		case "ollama":
-			return NewOllamaProvider("http://localhost:11434", model), nil
-		case "copilot":
-			copilotClient := copilot.New(config.GetConfigDir())
-			token, err := copilotClient.GetAPIToken()
+		if registration, ok := Lookup(providerPrefix); ok {
+			return registration, models.RealModelID(modelName), nil
+		}
+		// viper lowercases map keys, so endpoint names are matched in lowercase
+		if endpoint, ok := cfg.Endpoints[strings.ToLower(providerPrefix)]; ok {
+			return endpointRegistration(providerPrefix, endpoint), modelName, nil
+		}
+		return Registration{}, "", fmt.Errorf("unsupported provider: %s", providerPrefix)
+	}
+
+	// Infer from the alias, as the real name may not carry the prefix
+	registration, ok := Infer(modelName)
+	if !ok {
+		return Registration{}, "", fmt.Errorf("unsupported model: %s", modelName)
+	}
+	return registration, models.RealModelID(modelName), nil
+}
+
+// endpointRegistration describes a configured OpenAI-compatible endpoint
+func endpointRegistration(name string, endpoint config.Endpoint) Registration {
+	return Registration{
+		Name: name,
+		New: func(cfg *config.Config, apiKey, model string) (Provider, error) {
+			opts, err := openAIHTTPOptions(cfg)
 			if err != nil {
-				return nil, fmt.Errorf("failed to get Copilot token: %w", err)
+				return nil, err
 			}
-			provider, err := NewCopilotProvider(token, model)
+			provider, err := NewOpenAICompatibleProvider(name, endpoint.BaseURL, os.ExpandEnv(endpoint.APIKey), endpoint.Headers, model, opts...)
 			if err != nil {
-				return nil, fmt.Errorf("failed to initialize Copilot provider: %w", err)
+				return nil, err
 			}
+			provider.jsonSchema = endpoint.JSONSchema
 			return provider, nil
-		default:
-			return nil, fmt.Errorf("unsupported provider: %s", providerPrefix)
-		}
-	}
-
-	// If no provider specified, infer from model name
-	switch {
-	case strings.HasPrefix(model, "openai"):
-		return NewOpenAIProvider(os.Getenv("OPENAI_API_KEY"), model)
-	case strings.HasPrefix(model, "anthropic"):
-		return NewAnthropicProvider(os.Getenv("ANTHROPIC_API_KEY"), model), nil
-	case strings.HasPrefix(model, "gemini"):
-		return NewGeminiProvider(os.Getenv("GOOGLE_API_KEY"), model)
-	case strings.HasPrefix(model, "groq"):
-		return NewGroqProvider(os.Getenv("GROQ_API_KEY"), model), nil
-	case strings.HasPrefix(model, "llama"):
-		return NewOllamaProvider("http://localhost:11434", model), nil
-	case strings.HasPrefix(model, "copilot-"):
-		copilotClient := copilot.New(config.GetConfigDir())
-		token, err := copilotClient.GetAPIToken()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get Copilot token: %w", err)
-		}
-		provider, err := NewCopilotProvider(token, model)
-		if err != nil {
-			return nil, fmt.Errorf("failed to initialize Copilot provider: %w", err)
-		}
-		return provider, nil
-	default:
-		return nil, fmt.Errorf("unsupported model: %s", model)
+		},
 	}
 }
@@ -0,0 +1,181 @@
+// internal/providers/fallback.go
+package providers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+)
+
+// FallbackProvider answers with the first model of a chain that is
+// available. A model is skipped when it fails with an auth, rate-limit,
+// timeout or server error before any of its answer was received.
+type FallbackProvider struct {
+	models    []string
+	providers []Provider
+	// log receives the fallback notices
+	log io.Writer
+
+	mu       sync.Mutex
+	answered int
+}
+
+// newFallbackProvider initializes the models of a chain. Models that cannot
+// be initialized, for example for a missing API key, are skipped.
+func newFallbackProvider(cfg *config.Config, chain []string) (*FallbackProvider, error) {
+	fallback := &FallbackProvider{log: os.Stderr, answered: -1}
+
+	var errs []error
+	for _, modelID := range chain {
+		provider, err := initializeModel(cfg, modelID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", modelID, err))
+			continue
+		}
+		fallback.models = append(fallback.models, modelID)
+		fallback.providers = append(fallback.providers, provider)
+	}
+
+	if len(fallback.providers) == 0 {
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("empty fallback chain")
+		}
+		return nil, fmt.Errorf("no model of the fallback chain is available: %w", errors.Join(errs...))
+	}
+	return fallback, nil
+}
+
+func (p *FallbackProvider) Complete(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	var answer io.ReadCloser
+	err := p.try(ctx, req, func(provider Provider) error {
+		reader, err := provider.Complete(ctx, req)
+		if err == nil {
+			answer, err = peekAnswer(reader)
+		}
+		return err
+	})
+	return answer, err
+}
+
+// CompleteWithTools sends a request with tools along the chain. Models whose
+// provider cannot call tools are skipped.
+func (p *FallbackProvider) CompleteWithTools(ctx context.Context, req *Request) (*ToolResponse, error) {
+	var resp *ToolResponse
+	err := p.try(ctx, req, func(provider Provider) error {
+		caller, ok := provider.(ToolCaller)
+		if !ok {
+			return errToolsUnsupported
+		}
+		var err error
+		resp, err = caller.CompleteWithTools(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// unsupportedError reports a request a model cannot handle, such as images
+// for a model without vision. A chain skips such models.
+type unsupportedError struct {
+	msg string
+}
+
+func (e *unsupportedError) Error() string {
+	return e.msg
+}
+
+var errToolsUnsupported = &unsupportedError{"tool calling is not supported"}
+
+// try calls send with the providers of the chain in order until one of them
+// succeeds or fails with an error any model would fail with. Models rejected
+// by req.BeforeAttempt are skipped.
+func (p *FallbackProvider) try(ctx context.Context, req *Request, send func(Provider) error) error {
+	var lastErr error
+	for i, provider := range p.providers {
+		if i > 0 {
+			fmt.Fprintf(p.log, "%s failed: %v\nFalling back to %s\n", p.models[i-1], lastErr, p.models[i])
+		}
+		if req.BeforeAttempt != nil {
+			if err := req.BeforeAttempt(p.models[i], provider.Name()); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+
+		// Classify with the provider of the model, for the hint to fit it
+		err := ClassifyError(provider.Name(), send(provider))
+		if err == nil {
+			p.mu.Lock()
+			p.answered = i
+			p.mu.Unlock()
+			if i > 0 {
+				fmt.Fprintf(p.log, "Answered by %s\n", p.models[i])
+			}
+			return nil
+		}
+
+		// A cancelled request or a bad request fails with every model
+		var unsupported *unsupportedError
+		if ctx.Err() != nil || !(isUnavailable(err) || errors.As(err, &unsupported)) {
+			return err
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("all models of the fallback chain failed, last error: %w", lastErr)
+}
+
+// Name returns the name of the provider that answered the last request, or
+// of the first one in the chain before any request
+func (p *FallbackProvider) Name() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.answered >= 0 {
+		return p.providers[p.answered].Name()
+	}
+	return p.providers[0].Name()
+}
+
+// Answered returns the model ID that answered the last request, or an empty
+// string before any request was answered
+func (p *FallbackProvider) Answered() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.answered < 0 {
+		return ""
+	}
+	return p.models[p.answered]
+}
+
+// peekedAnswer replays the peeked start of an answer before the rest
+type peekedAnswer struct {
+	*bufio.Reader
+	body io.ReadCloser
+}
+
+func (a *peekedAnswer) Close() error {
+	return a.body.Close()
+}
+
+// Usage reports the usage of the underlying answer, if it has any
+func (a *peekedAnswer) Usage() Usage {
+	if reporter, ok := a.body.(UsageReporter); ok {
+		return reporter.Usage()
+	}
+	return Usage{}
+}
+
+// peekAnswer waits for the first byte of an answer, so that streamed errors
+// surface before any output is emitted
+func peekAnswer(body io.ReadCloser) (io.ReadCloser, error) {
+	answer := &peekedAnswer{Reader: bufio.NewReader(body), body: body}
+	if _, err := answer.Peek(1); err != nil && err != io.EOF {
+		body.Close()
+		return nil, err
+	}
+	return answer, nil
+}
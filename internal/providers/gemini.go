@@ -2,22 +2,78 @@ package providers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
+	"time"
 
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/pkg/chat"
 	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+// geminiHarmCategories maps config names to Gemini harm categories
+var geminiHarmCategories = map[string]genai.HarmCategory{
+	"harassment":        genai.HarmCategoryHarassment,
+	"hate_speech":       genai.HarmCategoryHateSpeech,
+	"sexually_explicit": genai.HarmCategorySexuallyExplicit,
+	"dangerous_content": genai.HarmCategoryDangerousContent,
+}
+
+// geminiBlockThresholds maps config names to Gemini block thresholds
+var geminiBlockThresholds = map[string]genai.HarmBlockThreshold{
+	"block_none":             genai.HarmBlockNone,
+	"block_only_high":        genai.HarmBlockOnlyHigh,
+	"block_medium_and_above": genai.HarmBlockMediumAndAbove,
+	"block_low_and_above":    genai.HarmBlockLowAndAbove,
+}
+
+func init() {
+	Register(Registration{
+		Name:     "gemini",
+		Prefixes: []string{"gemini"},
+		Credential: &Credential{
+			EnvVar:     "GOOGLE_API_KEY",
+			ConfigKey:  "gemini_api_key",
+			FromConfig: func(cfg *config.Config) string { return cfg.GeminiKey },
+		},
+		New: func(cfg *config.Config, apiKey, model string) (Provider, error) {
+			client, err := geminiHTTPClient(apiKey, cfg)
+			if err != nil {
+				return nil, err
+			}
+			provider, err := NewGeminiProvider(apiKey, model, cfg.GeminiSafety, option.WithHTTPClient(client))
+			if err != nil {
+				return nil, err
+			}
+			return provider, nil
+		},
+	})
+}
+
 type GeminiProvider struct {
 	client *genai.Client
 	model  string
+	safety []*genai.SafetySetting
 }
 
-func NewGeminiProvider(apiKey, model string) (*GeminiProvider, error) {
+// NewGeminiProvider creates a new Gemini provider. safety maps harm
+// categories (harassment, hate_speech, sexually_explicit, dangerous_content)
+// to block thresholds (block_none, block_only_high, block_medium_and_above,
+// block_low_and_above); categories not listed use the API defaults. opts are
+// passed on to the SDK client.
+func NewGeminiProvider(apiKey, model string, safety map[string]string, opts ...option.ClientOption) (*GeminiProvider, error) {
+	settings, err := parseGeminiSafetySettings(safety)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	client, err := genai.NewClient(ctx, append([]option.ClientOption{option.WithAPIKey(apiKey)}, opts...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -25,23 +81,409 @@ func NewGeminiProvider(apiKey, model string) (*GeminiProvider, error) {
 	return &GeminiProvider{
 		client: client,
 		model:  model,
+		safety: settings,
 	}, nil
 }
 
-func (p *GeminiProvider) Complete(ctx context.Context, prompt string, stream bool) (io.ReadCloser, error) {
+// geminiKeyTransport adds the API key to requests, which the SDK leaves to
+// the HTTP client when it is given one
+type geminiKeyTransport struct {
+	apiKey string
+	base   http.RoundTripper
+}
+
+func (t *geminiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("x-goog-api-key", t.apiKey)
+	return t.base.RoundTrip(req)
+}
+
+// geminiHTTPClient returns the HTTP client of NewHTTPClient authenticating
+// with apiKey
+func geminiHTTPClient(apiKey string, cfg *config.Config) (*http.Client, error) {
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = &geminiKeyTransport{apiKey: apiKey, base: client.Transport}
+	return client, nil
+}
+
+// parseGeminiSafetySettings converts the configured safety settings
+func parseGeminiSafetySettings(safety map[string]string) ([]*genai.SafetySetting, error) {
+	var settings []*genai.SafetySetting
+	for name, value := range safety {
+		category, ok := geminiHarmCategories[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown gemini harm category: %s", name)
+		}
+		threshold, ok := geminiBlockThresholds[strings.ToLower(value)]
+		if !ok {
+			return nil, fmt.Errorf("unknown gemini block threshold for %s: %s", name, value)
+		}
+		settings = append(settings, &genai.SafetySetting{Category: category, Threshold: threshold})
+	}
+	return settings, nil
+}
+
+// geminiContents converts the request turns into Gemini contents. Gemini
+// calls the assistant role "model".
+func geminiContents(req *Request) []*genai.Content {
+	turns := req.Turns()
+	names := toolNames(turns)
+
+	var contents []*genai.Content
+	var inResults bool
+	for _, msg := range turns {
+		switch msg.Role {
+		case chat.RoleTool:
+			part := genai.FunctionResponse{
+				Name:     names[msg.ToolCallID],
+				Response: map[string]any{"output": msg.Content},
+			}
+			// The results of one turn of tool calls are sent together
+			if inResults {
+				last := contents[len(contents)-1]
+				last.Parts = append(last.Parts, part)
+			} else {
+				contents = append(contents, &genai.Content{Role: "user", Parts: []genai.Part{part}})
+			}
+			inResults = true
+			continue
+		case chat.RoleAssistant:
+			var parts []genai.Part
+			if msg.Content != "" || len(msg.ToolCalls) == 0 {
+				parts = append(parts, genai.Text(msg.Content))
+			}
+			for _, call := range msg.ToolCalls {
+				args, err := ParseToolArguments(call)
+				if err != nil {
+					args = map[string]interface{}{}
+				}
+				parts = append(parts, genai.FunctionCall{Name: call.Name, Args: args})
+			}
+			contents = append(contents, &genai.Content{Role: "model", Parts: parts})
+		default:
+			parts := []genai.Part{genai.Text(msg.Content)}
+			for _, image := range msg.Images {
+				parts = append(parts, genai.Blob{MIMEType: image.MIMEType, Data: image.Data})
+			}
+			contents = append(contents, &genai.Content{Role: "user", Parts: parts})
+		}
+		inResults = false
+	}
+	return contents
+}
+
+// applyGeminiOptions sets the generation parameters on the model. Gemini has
+// no seed parameter.
+func applyGeminiOptions(model *genai.GenerativeModel, opts config.GenerationOptions) {
+	if opts.Temperature != nil {
+		model.SetTemperature(float32(*opts.Temperature))
+	}
+	if opts.MaxTokens != nil {
+		model.SetMaxOutputTokens(int32(*opts.MaxTokens))
+	}
+	if opts.TopP != nil {
+		model.SetTopP(float32(*opts.TopP))
+	}
+	model.StopSequences = opts.Stop
+}
+
+// geminiText concatenates the text parts of the first candidate and reports
+// finish reasons that mean the answer is unusable
+func geminiText(resp *genai.GenerateContentResponse) (string, error) {
+	if len(resp.Candidates) == 0 {
+		return "", nil
+	}
+
+	candidate := resp.Candidates[0]
+	switch candidate.FinishReason {
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation:
+		return "", newError(KindContentFiltered, "gemini", fmt.Errorf("gemini stopped the response: %s", geminiFinishReason(candidate.FinishReason)))
+	case genai.FinishReasonOther:
+		return "", fmt.Errorf("gemini stopped the response: %s", geminiFinishReason(candidate.FinishReason))
+	}
+
+	if candidate.Content == nil {
+		return "", nil
+	}
+
+	var text strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			text.WriteString(string(t))
+		}
+	}
+	return text.String(), nil
+}
+
+// geminiFinishReason describes a finish reason in plain words
+func geminiFinishReason(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonSafety:
+		return "the content was flagged by the safety filters"
+	case genai.FinishReasonRecitation:
+		return "the content was flagged for reciting training data"
+	case genai.FinishReasonMaxTokens:
+		return "the maximum number of tokens was reached"
+	default:
+		return reason.String()
+	}
+}
+
+// geminiUsage records the usage metadata and finish reason of a response.
+// Streamed responses report the running totals, so the last one wins.
+func geminiUsage(u *Usage, resp *genai.GenerateContentResponse) {
+	if meta := resp.UsageMetadata; meta != nil {
+		u.InputTokens = int(meta.PromptTokenCount)
+		u.OutputTokens = int(meta.CandidatesTokenCount)
+		u.CachedTokens = int(meta.CachedContentTokenCount)
+	}
+	if len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason != genai.FinishReasonUnspecified {
+		reason := resp.Candidates[0].FinishReason.String()
+		u.FinishReason = strings.ToLower(strings.TrimPrefix(reason, "FinishReason"))
+	}
+}
+
+// geminiError turns SDK block errors into readable errors
+func geminiError(err error) error {
+	var blocked *genai.BlockedError
+	if !errors.As(err, &blocked) {
+		return err
+	}
+	if blocked.PromptFeedback != nil {
+		return newError(KindContentFiltered, "gemini", fmt.Errorf("gemini blocked the prompt: %s", blocked.PromptFeedback.BlockReason))
+	}
+	if blocked.Candidate != nil {
+		return newError(KindContentFiltered, "gemini", fmt.Errorf("gemini stopped the response: %s", geminiFinishReason(blocked.Candidate.FinishReason)))
+	}
+	return err
+}
+
+// geminiTypes maps JSON schema types to Gemini schema types
+var geminiTypes = map[string]genai.Type{
+	"string":  genai.TypeString,
+	"integer": genai.TypeInteger,
+	"number":  genai.TypeNumber,
+	"boolean": genai.TypeBoolean,
+}
+
+// geminiSchema converts a JSON Schema into a Gemini schema. It fails for
+// keywords Gemini does not support, such as anyOf or a list of types.
+func geminiSchema(schema map[string]interface{}) (*genai.Schema, bool) {
+	name, _ := schema["type"].(string)
+	if _, ok := schema["anyOf"]; ok {
+		return nil, false
+	}
+
+	converted := &genai.Schema{}
+	converted.Description, _ = schema["description"].(string)
+	switch name {
+	case "object":
+		converted.Type = genai.TypeObject
+		properties, _ := schema["properties"].(map[string]interface{})
+		converted.Properties = make(map[string]*genai.Schema, len(properties))
+		for key, value := range properties {
+			property, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if converted.Properties[key], ok = geminiSchema(property); !ok {
+				return nil, false
+			}
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, key := range required {
+			if s, ok := key.(string); ok {
+				converted.Required = append(converted.Required, s)
+			}
+		}
+	case "array":
+		items, ok := schema["items"].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if converted.Items, ok = geminiSchema(items); !ok {
+			return nil, false
+		}
+		converted.Type = genai.TypeArray
+	default:
+		t, ok := geminiTypes[name]
+		if !ok {
+			return nil, false
+		}
+		converted.Type = t
+		enum, _ := schema["enum"].([]interface{})
+		for _, value := range enum {
+			s, ok := value.(string)
+			if !ok {
+				return nil, false
+			}
+			converted.Enum = append(converted.Enum, s)
+		}
+	}
+	return converted, true
+}
+
+// geminiTools converts tools into Gemini function declarations
+func geminiTools(tools []Tool) []*genai.Tool {
+	declarations := make([]*genai.FunctionDeclaration, 0, len(tools))
+	for _, tool := range tools {
+		schema := &genai.Schema{Type: genai.TypeObject, Properties: make(map[string]*genai.Schema)}
+		for _, param := range tool.Parameters {
+			paramType, ok := geminiTypes[param.Type]
+			if !ok {
+				paramType = genai.TypeString
+			}
+			schema.Properties[param.Name] = &genai.Schema{Type: paramType, Description: param.Description}
+			if param.Required {
+				schema.Required = append(schema.Required, param.Name)
+			}
+		}
+		declarations = append(declarations, &genai.FunctionDeclaration{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  schema,
+		})
+	}
+	return []*genai.Tool{{FunctionDeclarations: declarations}}
+}
+
+// startChat sets up a chat session for the request. The last turn is
+// returned as the parts of the new message, everything before it is history.
+func (p *GeminiProvider) startChat(req *Request) (*genai.ChatSession, []genai.Part, error) {
+	if err := checkVision(p.model, req); err != nil {
+		return nil, nil, err
+	}
 	model := p.client.GenerativeModel(p.model)
+	model.SafetySettings = p.safety
+	applyGeminiOptions(model, req.Options)
+	if system := req.SystemPrompt(); system != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(system))
+	}
+	if len(req.Tools) > 0 {
+		model.Tools = geminiTools(req.Tools)
+	}
+	if req.Schema != nil {
+		model.ResponseMIMEType = "application/json"
+		// Schemas Gemini cannot express are left to the prompt
+		if schema, ok := geminiSchema(req.Schema); ok {
+			model.ResponseSchema = schema
+		}
+	}
+
+	contents := geminiContents(req)
+	if len(contents) == 0 {
+		return nil, nil, fmt.Errorf("no messages to send to Gemini API")
+	}
 
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	session := model.StartChat()
+	session.History = contents[:len(contents)-1]
+	return session, contents[len(contents)-1].Parts, nil
+}
+
+func (p *GeminiProvider) Complete(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	start := time.Now()
+	session, parts, err := p.startChat(req)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+	if req.Stream {
+		return p.streamCompletion(ctx, session, parts, start), nil
+	}
+
+	result, err := session.SendMessage(ctx, parts...)
+	if err != nil {
+		return nil, geminiError(err)
+	}
+
+	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil {
 		return nil, fmt.Errorf("no content returned from Gemini API")
 	}
 
-	text := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
-	return io.NopCloser(strings.NewReader(text)), nil
+	text, err := geminiText(result)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := newResponse(io.NopCloser(strings.NewReader(text)), p.model, start)
+	resp.update(func(u *Usage) { geminiUsage(u, result) })
+	return resp, nil
+}
+
+func (p *GeminiProvider) streamCompletion(ctx context.Context, session *genai.ChatSession, parts []genai.Part, start time.Time) io.ReadCloser {
+	iter := session.SendMessageStream(ctx, parts...)
+
+	reader, writer := io.Pipe()
+	resp := newResponse(reader, p.model, start)
+
+	go func() {
+		defer closeOnCancel(ctx, writer)()
+		for {
+			result, err := iter.Next()
+			if err == iterator.Done {
+				writer.Close()
+				return
+			}
+			if err != nil {
+				writer.CloseWithError(geminiError(err))
+				return
+			}
+			resp.update(func(u *Usage) { geminiUsage(u, result) })
+
+			text, err := geminiText(result)
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			if text == "" {
+				continue
+			}
+			// A failed write means the reader was closed, so stop consuming
+			if _, err := writer.Write([]byte(text)); err != nil {
+				return
+			}
+		}
+	}()
+
+	return resp
+}
+
+func (p *GeminiProvider) CompleteWithTools(ctx context.Context, req *Request) (*ToolResponse, error) {
+	start := time.Now()
+	session, parts, err := p.startChat(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := session.SendMessage(ctx, parts...)
+	if err != nil {
+		return nil, geminiError(err)
+	}
+	text, err := geminiText(result)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ToolResponse{Content: text, Usage: Usage{Model: p.model}}
+	geminiUsage(&resp.Usage, result)
+	resp.Usage.Latency = time.Since(start)
+	if len(result.Candidates) > 0 && result.Candidates[0].Content != nil {
+		for i, part := range result.Candidates[0].Content.Parts {
+			if call, ok := part.(genai.FunctionCall); ok {
+				// Gemini matches results to calls by name, the ID only
+				// needs to be unique within the turn
+				resp.ToolCalls = append(resp.ToolCalls, chat.ToolCall{
+					ID:        fmt.Sprintf("%s-%d", call.Name, i),
+					Name:      call.Name,
+					Arguments: toolArguments(call.Args),
+				})
+			}
+		}
+	}
+	return resp, nil
 }
 
 func (p *GeminiProvider) Name() string {
@@ -8,24 +8,62 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/pkg/chat"
 )
 
+func init() {
+	Register(Registration{
+		Name:     "groq",
+		Prefixes: []string{"groq"},
+		Credential: &Credential{
+			EnvVar:     "GROQ_API_KEY",
+			ConfigKey:  "groq_api_key",
+			FromConfig: func(cfg *config.Config) string { return cfg.GroqKey },
+		},
+		New: func(cfg *config.Config, apiKey, model string) (Provider, error) {
+			provider := NewGroqProvider(apiKey, model)
+			client, err := NewHTTPClient(cfg)
+			if err != nil {
+				return nil, err
+			}
+			provider.client = client
+			return provider, nil
+		},
+	})
+}
+
 type GroqProvider struct {
+	client *http.Client
 	apiKey string
 	model  string
 }
 
 func NewGroqProvider(apiKey, model string) *GroqProvider {
 	return &GroqProvider{
+		client: &http.Client{},
 		apiKey: apiKey,
 		model:  model,
 	}
 }
 
 type groqRequest struct {
-	Model    string        `json:"model"`
-	Messages []groqMessage `json:"messages"`
-	Stream   bool          `json:"stream"`
+	Model       string        `json:"model"`
+	Messages    []groqMessage `json:"messages"`
+	Stream      bool          `json:"stream"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	MaxTokens   *int          `json:"max_tokens,omitempty"`
+	TopP        *float64      `json:"top_p,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+	Seed        *int          `json:"seed,omitempty"`
+	// ResponseFormat enables the JSON mode
+	ResponseFormat *groqResponseFormat `json:"response_format,omitempty"`
+}
+
+type groqResponseFormat struct {
+	Type string `json:"type"`
 }
 
 type groqMessage struct {
@@ -33,13 +71,30 @@ type groqMessage struct {
 	Content string `json:"content"`
 }
 
-func (p *GroqProvider) Complete(ctx context.Context, prompt string, stream bool) (io.ReadCloser, error) {
+func (p *GroqProvider) Complete(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	if err := rejectImages("groq", req); err != nil {
+		return nil, err
+	}
+	var messages []groqMessage
+	if system := req.SystemPrompt(); system != "" {
+		messages = append(messages, groqMessage{Role: chat.RoleSystem, Content: system})
+	}
+	for _, msg := range req.Turns() {
+		messages = append(messages, groqMessage{Role: msg.Role, Content: msg.Content})
+	}
+
 	reqBody := groqRequest{
-		Model: strings.TrimPrefix(p.model, "groq-"),
-		Messages: []groqMessage{
-			{Role: "user", Content: prompt},
-		},
-		Stream: stream,
+		Model:       strings.TrimPrefix(p.model, "groq-"),
+		Messages:    messages,
+		Stream:      req.Stream,
+		Temperature: req.Options.Temperature,
+		MaxTokens:   req.Options.MaxTokens,
+		TopP:        req.Options.TopP,
+		Stop:        req.Options.Stop,
+		Seed:        req.Options.Seed,
+	}
+	if req.Schema != nil {
+		reqBody.ResponseFormat = &groqResponseFormat{Type: "json_object"}
 	}
 
 	body, err := json.Marshal(reqBody)
@@ -47,26 +102,31 @@ func (p *GroqProvider) Complete(ctx context.Context, prompt string, stream bool)
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.groq.com/openai/v1/chat/completions", strings.NewReader(string(body)))
+	start := time.Now()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.groq.com/openai/v1/chat/completions", strings.NewReader(string(body)))
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return nil, fmt.Errorf("groq API error: %s", resp.Status)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &HTTPError{Provider: "groq", StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+
+	if !req.Stream {
+		return decodeChatCompletion(resp.Body, reqBody.Model, start)
 	}
 
-	return resp.Body, nil
+	return newSSEReader(resp.Body, reqBody.Model, start), nil
 }
 
 func (p *GroqProvider) Name() string {
@@ -0,0 +1,46 @@
+// internal/providers/images.go
+package providers
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/acazau/shell-ask-go/internal/models"
+	"github.com/acazau/shell-ask-go/pkg/chat"
+)
+
+// hasImages reports whether any message of the request carries images
+func hasImages(req *Request) bool {
+	for _, msg := range req.Messages {
+		if len(msg.Images) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVision fails for a request with images to a model known not to
+// accept them, instead of sending the request without them
+func checkVision(model string, req *Request) error {
+	if !hasImages(req) {
+		return nil
+	}
+	if supported, known := models.SupportsVision(model); known && !supported {
+		return &unsupportedError{fmt.Sprintf("%s does not accept images, use a vision model such as gpt-4o, claude-3-5-sonnet-latest or gemini-1.5-flash", model)}
+	}
+	return nil
+}
+
+// rejectImages fails for a request with images to a provider that cannot
+// send them
+func rejectImages(provider string, req *Request) error {
+	if hasImages(req) {
+		return &unsupportedError{fmt.Sprintf("%s does not support images, use an OpenAI, Anthropic, Gemini or Ollama vision model", provider)}
+	}
+	return nil
+}
+
+// imageDataURL encodes an image as a data URL
+func imageDataURL(image chat.Image) string {
+	return fmt.Sprintf("data:%s;base64,%s", image.MIMEType, base64.StdEncoding.EncodeToString(image.Data))
+}
@@ -0,0 +1,299 @@
+// internal/providers/mock.go
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/models"
+	"github.com/acazau/shell-ask-go/pkg/chat"
+)
+
+func init() {
+	Register(Registration{
+		Name: "mock",
+		// Only selected explicitly, as in mock/echo
+		New: func(cfg *config.Config, apiKey, model string) (Provider, error) {
+			provider, err := NewMockProvider(model)
+			if err != nil {
+				return nil, err
+			}
+			return provider, nil
+		},
+	})
+}
+
+// mockErrorKinds name the error kinds a mock answer can fail with
+var mockErrorKinds = map[string]ErrorKind{
+	"unknown":          KindUnknown,
+	"missing_key":      KindMissingKey,
+	"invalid_key":      KindInvalidKey,
+	"rate_limited":     KindRateLimited,
+	"context_too_long": KindContextTooLong,
+	"content_filtered": KindContentFiltered,
+	"network":          KindNetwork,
+	"model_not_found":  KindModelNotFound,
+	"server":           KindServer,
+}
+
+// MockProvider answers without any network access, for tests and scripts.
+// The echo model answers with the last user message, the fixture model with
+// the answers of a JSON file in turn, repeating the last one.
+type MockProvider struct {
+	model     string
+	responses []mockResponse
+	// overrides are the options given in the model ID
+	overrides mockResponse
+
+	mu    sync.Mutex
+	calls int
+}
+
+// mockFixture is the content of a fixture file
+type mockFixture struct {
+	Responses []mockResponse `json:"responses"`
+}
+
+// mockResponse is one answer of the mock provider
+type mockResponse struct {
+	Content string `json:"content"`
+	// Chunks are the pieces a streamed answer arrives in. They default to
+	// the words of Content.
+	Chunks    []string       `json:"chunks"`
+	ToolCalls []mockToolCall `json:"tool_calls"`
+	Usage     *struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	FinishReason string `json:"finish_reason"`
+	// Latency is the wait before the answer starts, ChunkDelay the wait
+	// between streamed chunks, both as durations such as "100ms"
+	Latency    string `json:"latency"`
+	ChunkDelay string `json:"chunk_delay"`
+	// Error fails the request with an error of the named kind, such as
+	// rate_limited
+	Error string `json:"error"`
+}
+
+type mockToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// NewMockProvider creates a mock provider for a model ID of the form
+// echo or fixture:path, optionally followed by options in URL query form,
+// as in "echo?latency=1s&chunk_delay=50ms&error=rate_limited"
+func NewMockProvider(model string) (*MockProvider, error) {
+	spec, query, _ := strings.Cut(model, "?")
+	options, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mock options %q: %w", query, err)
+	}
+
+	provider := &MockProvider{
+		model: spec,
+		overrides: mockResponse{
+			Latency:    options.Get("latency"),
+			ChunkDelay: options.Get("chunk_delay"),
+			Error:      options.Get("error"),
+		},
+	}
+	if err := provider.overrides.validate(); err != nil {
+		return nil, err
+	}
+
+	if path, ok := strings.CutPrefix(spec, "fixture:"); ok {
+		provider.model = "fixture"
+		if provider.responses, err = loadMockFixture(path); err != nil {
+			return nil, err
+		}
+	} else if spec != "echo" {
+		return nil, fmt.Errorf("unknown mock model %q, use mock/echo or mock/fixture:file.json", spec)
+	}
+	return provider, nil
+}
+
+func loadMockFixture(path string) ([]mockResponse, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock fixture: %w", err)
+	}
+	var fixture mockFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid mock fixture %s: %w", path, err)
+	}
+	if len(fixture.Responses) == 0 {
+		return nil, fmt.Errorf("mock fixture %s has no responses", path)
+	}
+	for i, resp := range fixture.Responses {
+		if err := resp.validate(); err != nil {
+			return nil, fmt.Errorf("mock fixture %s, response %d: %w", path, i+1, err)
+		}
+	}
+	return fixture.Responses, nil
+}
+
+func (r mockResponse) validate() error {
+	for _, d := range []string{r.Latency, r.ChunkDelay} {
+		if _, err := parseMockDuration(d); err != nil {
+			return err
+		}
+	}
+	if _, ok := mockErrorKinds[r.Error]; r.Error != "" && !ok {
+		return fmt.Errorf("unknown mock error %q", r.Error)
+	}
+	return nil
+}
+
+func parseMockDuration(d string) (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(d)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mock duration %q: %w", d, err)
+	}
+	return duration, nil
+}
+
+// next returns the answer to req, with the options of the model ID applied
+func (p *MockProvider) next(req *Request) mockResponse {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var resp mockResponse
+	if p.responses == nil {
+		turns := req.Turns()
+		for i := len(turns) - 1; i >= 0; i-- {
+			if turns[i].Role == chat.RoleUser {
+				resp.Content = turns[i].Content
+				break
+			}
+		}
+	} else {
+		resp = p.responses[min(p.calls, len(p.responses)-1)]
+	}
+	p.calls++
+
+	if p.overrides.Latency != "" {
+		resp.Latency = p.overrides.Latency
+	}
+	if p.overrides.ChunkDelay != "" {
+		resp.ChunkDelay = p.overrides.ChunkDelay
+	}
+	if p.overrides.Error != "" {
+		resp.Error = p.overrides.Error
+	}
+	return resp
+}
+
+// usage returns the usage of an answer, estimated unless the fixture gives it
+func (r mockResponse) usage(model string, req *Request) Usage {
+	usage := Usage{Model: model, FinishReason: r.FinishReason}
+	if usage.FinishReason == "" {
+		usage.FinishReason = "stop"
+	}
+	if r.Usage != nil {
+		usage.InputTokens = r.Usage.InputTokens
+		usage.OutputTokens = r.Usage.OutputTokens
+		return usage
+	}
+
+	prompt := req.SystemPrompt()
+	for _, msg := range req.Turns() {
+		prompt += msg.Content
+	}
+	usage.InputTokens = models.EstimateTokens(prompt)
+	usage.OutputTokens = models.EstimateTokens(r.Content)
+	return usage
+}
+
+// start waits for the latency and returns the injected error, if any
+func (r mockResponse) start(ctx context.Context) error {
+	latency, _ := parseMockDuration(r.Latency)
+	if err := sleepContext(ctx, latency); err != nil {
+		return err
+	}
+	if r.Error != "" {
+		return newError(mockErrorKinds[r.Error], "mock", fmt.Errorf("injected %s error", r.Error))
+	}
+	return nil
+}
+
+func (p *MockProvider) Complete(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	start := time.Now()
+	answer := p.next(req)
+	if err := answer.start(ctx); err != nil {
+		return nil, err
+	}
+
+	chunks := answer.Chunks
+	if len(chunks) == 0 {
+		chunks = strings.SplitAfter(answer.Content, " ")
+	}
+	if !req.Stream {
+		chunks = []string{strings.Join(chunks, "")}
+	}
+	delay, _ := parseMockDuration(answer.ChunkDelay)
+
+	reader, writer := io.Pipe()
+	resp := newResponse(reader, p.model, start)
+	resp.update(func(u *Usage) { *u = answer.usage(p.model, req) })
+
+	go func() {
+		defer closeOnCancel(ctx, writer)()
+		for i, chunk := range chunks {
+			if i > 0 {
+				if err := sleepContext(ctx, delay); err != nil {
+					writer.CloseWithError(err)
+					return
+				}
+			}
+			// A failed write means the reader was closed
+			if _, err := writer.Write([]byte(chunk)); err != nil {
+				return
+			}
+		}
+		writer.Close()
+	}()
+	return resp, nil
+}
+
+func (p *MockProvider) CompleteWithTools(ctx context.Context, req *Request) (*ToolResponse, error) {
+	start := time.Now()
+	answer := p.next(req)
+	if err := answer.start(ctx); err != nil {
+		return nil, err
+	}
+
+	resp := &ToolResponse{Content: answer.Content, Usage: answer.usage(p.model, req)}
+	for i, call := range answer.ToolCalls {
+		id := call.ID
+		if id == "" {
+			id = fmt.Sprintf("call-%d", i)
+		}
+		arguments := string(call.Arguments)
+		if arguments == "" {
+			arguments = "{}"
+		}
+		resp.ToolCalls = append(resp.ToolCalls, chat.ToolCall{ID: id, Name: call.Name, Arguments: arguments})
+	}
+	if len(resp.ToolCalls) > 0 && answer.FinishReason == "" {
+		resp.Usage.FinishReason = "tool_calls"
+	}
+	resp.Usage.Latency = time.Since(start)
+	return resp, nil
+}
+
+func (p *MockProvider) Name() string {
+	return "mock"
+}
@@ -5,55 +5,251 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/models"
+	"github.com/acazau/shell-ask-go/pkg/chat"
 	"github.com/ollama/ollama/api"
 )
 
+const defaultOllamaHost = "http://localhost:11434"
+
+func init() {
+	Register(Registration{
+		Name:     "ollama",
+		Prefixes: []string{"ollama-", "llama"},
+		// Ollama models are usually named name:tag
+		Match: models.ValidateOllamaModel,
+		New: func(cfg *config.Config, apiKey, model string) (Provider, error) {
+			provider := NewOllamaProvider(ollamaHost(cfg), model)
+			client, err := NewHTTPClient(cfg)
+			if err != nil {
+				return nil, err
+			}
+			provider.client = client
+			return provider, nil
+		},
+	})
+}
+
+// ollamaHost returns the Ollama host from the config, falling back to the
+// OLLAMA_HOST environment variable used by the ollama CLI
+func ollamaHost(cfg *config.Config) string {
+	if cfg.OllamaHost != "" {
+		return cfg.OllamaHost
+	}
+	if host := os.Getenv("OLLAMA_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return host
+	}
+	return defaultOllamaHost
+}
+
 type OllamaProvider struct {
-	host  string
-	model string
+	client *http.Client
+	host   string
+	model  string
 }
 
 func NewOllamaProvider(host, model string) *OllamaProvider {
 	if host == "" {
-		host = "http://localhost:11434"
+		host = defaultOllamaHost
 	}
 	return &OllamaProvider{
-		host:  host,
-		model: model,
+		client: http.DefaultClient,
+		host:   host,
+		model:  model,
 	}
 }
 
+// ollamaOptions maps the generation parameters to Ollama model options
+func ollamaOptions(opts config.GenerationOptions) map[string]interface{} {
+	options := make(map[string]interface{})
+	if opts.Temperature != nil {
+		options["temperature"] = *opts.Temperature
+	}
+	if opts.MaxTokens != nil {
+		options["num_predict"] = *opts.MaxTokens
+	}
+	if opts.TopP != nil {
+		options["top_p"] = *opts.TopP
+	}
+	if len(opts.Stop) > 0 {
+		options["stop"] = opts.Stop
+	}
+	if opts.Seed != nil {
+		options["seed"] = *opts.Seed
+	}
+	return options
+}
 
-func (p *OllamaProvider) Complete(ctx context.Context, prompt string, stream bool) (io.ReadCloser, error) {
-	client, err := api.ClientFromEnvironment()
+// ollamaMessages converts a request into Ollama chat messages
+func ollamaMessages(req *Request) []api.Message {
+	var messages []api.Message
+	if system := req.SystemPrompt(); system != "" {
+		messages = append(messages, api.Message{Role: chat.RoleSystem, Content: system})
+	}
+	for _, msg := range req.Turns() {
+		message := api.Message{Role: msg.Role, Content: msg.Content}
+		for _, image := range msg.Images {
+			message.Images = append(message.Images, api.ImageData(image.Data))
+		}
+		for _, call := range msg.ToolCalls {
+			args, err := ParseToolArguments(call)
+			if err != nil {
+				args = map[string]interface{}{}
+			}
+			message.ToolCalls = append(message.ToolCalls, api.ToolCall{
+				Function: api.ToolCallFunction{Name: call.Name, Arguments: args},
+			})
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// ollamaTools converts tools into Ollama function tools
+func ollamaTools(tools []Tool) []api.Tool {
+	converted := make([]api.Tool, 0, len(tools))
+	for _, tool := range tools {
+		function := api.ToolFunction{Name: tool.Name, Description: tool.Description}
+		function.Parameters.Type = "object"
+		function.Parameters.Required = []string{}
+		function.Parameters.Properties = make(map[string]struct {
+			Type        string   `json:"type"`
+			Description string   `json:"description"`
+			Enum        []string `json:"enum,omitempty"`
+		})
+		for _, param := range tool.Parameters {
+			property := function.Parameters.Properties[param.Name]
+			property.Type = param.Type
+			property.Description = param.Description
+			function.Parameters.Properties[param.Name] = property
+			if param.Required {
+				function.Parameters.Required = append(function.Parameters.Required, param.Name)
+			}
+		}
+		converted = append(converted, api.Tool{Type: "function", Function: function})
+	}
+	return converted
+}
+
+// chatRequest builds the Ollama client and chat request for a request.
+// Requests with images are refused for models without a vision projector.
+func (p *OllamaProvider) chatRequest(ctx context.Context, req *Request, stream bool) (*api.Client, *api.ChatRequest, error) {
+	base, err := url.Parse(p.host)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("invalid ollama host %q: %w", p.host, err)
 	}
+	client := api.NewClient(base, p.client)
+	model := strings.TrimPrefix(p.model, "ollama-")
 
-	streamPtr := &stream
-	req := &api.GenerateRequest{
-		Model:  strings.TrimPrefix(p.model, "ollama-"),
-		Prompt: prompt,
-		Stream: streamPtr,
+	if hasImages(req) {
+		info, err := client.Show(ctx, &api.ShowRequest{Model: model})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(info.ProjectorInfo) == 0 {
+			return nil, nil, &unsupportedError{fmt.Sprintf("%s does not accept images, use a vision model such as llava or llama3.2-vision", model)}
+		}
 	}
 
-	respFunc := func(resp api.GenerateResponse) error {
-		// Only print the response here; GenerateResponse has a number of other
-		// interesting fields you want to examine.
-		fmt.Println(resp.Response)
-		return nil
+	chatReq := &api.ChatRequest{
+		Model:    model,
+		Messages: ollamaMessages(req),
+		Stream:   &stream,
+		Options:  ollamaOptions(req.Options),
+	}
+	if len(req.Tools) > 0 {
+		chatReq.Tools = ollamaTools(req.Tools)
+	}
+	if req.Schema != nil {
+		chatReq.Format = "json"
+	}
+	return client, chatReq, nil
+}
+
+func (p *OllamaProvider) Complete(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	start := time.Now()
+	client, chatReq, err := p.chatRequest(ctx, req, req.Stream)
+	if err != nil {
+		return nil, err
 	}
 
-	err = client.Generate(ctx, req, respFunc)
+	reader, writer := io.Pipe()
+	resp := newResponse(reader, chatReq.Model, start)
+
+	go func() {
+		defer closeOnCancel(ctx, writer)()
+		// The callback runs once per chunk, or once in total when streaming is
+		// disabled. A failed write means the reader was closed, which aborts
+		// the request.
+		err := client.Chat(ctx, chatReq, func(chunk api.ChatResponse) error {
+			if chunk.Done {
+				// Only the final chunk carries the token counts
+				resp.update(func(u *Usage) {
+					if chunk.Model != "" {
+						u.Model = chunk.Model
+					}
+					u.InputTokens = chunk.PromptEvalCount
+					u.OutputTokens = chunk.EvalCount
+					u.FinishReason = chunk.DoneReason
+				})
+			}
+			if chunk.Message.Content == "" {
+				return nil
+			}
+			_, err := writer.Write([]byte(chunk.Message.Content))
+			return err
+		})
+		writer.CloseWithError(err)
+	}()
+
+	return resp, nil
+}
+
+func (p *OllamaProvider) CompleteWithTools(ctx context.Context, req *Request) (*ToolResponse, error) {
+	start := time.Now()
+	client, chatReq, err := p.chatRequest(ctx, req, false)
 	if err != nil {
 		return nil, err
 	}
 
-	// Since we are not returning the response body directly, we need to handle it differently.
-	// For now, we will return an empty io.ReadCloser and handle the response in the respFunc.
-	return io.NopCloser(strings.NewReader("")), nil
+	resp := &ToolResponse{Usage: Usage{Model: chatReq.Model}}
+	var content strings.Builder
+	err = client.Chat(ctx, chatReq, func(chunk api.ChatResponse) error {
+		content.WriteString(chunk.Message.Content)
+		for _, call := range chunk.Message.ToolCalls {
+			// Ollama does not identify calls, so they are numbered
+			resp.ToolCalls = append(resp.ToolCalls, chat.ToolCall{
+				ID:        fmt.Sprintf("%s-%d", call.Function.Name, len(resp.ToolCalls)),
+				Name:      call.Function.Name,
+				Arguments: toolArguments(call.Function.Arguments),
+			})
+		}
+		if chunk.Done {
+			if chunk.Model != "" {
+				resp.Usage.Model = chunk.Model
+			}
+			resp.Usage.InputTokens = chunk.PromptEvalCount
+			resp.Usage.OutputTokens = chunk.EvalCount
+			resp.Usage.FinishReason = chunk.DoneReason
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Content = content.String()
+	resp.Usage.Latency = time.Since(start)
+	return resp, nil
 }
 
 func (p *OllamaProvider) Name() string {
@@ -5,69 +5,355 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/pkg/chat"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 )
 
+func init() {
+	Register(Registration{
+		Name:     "openai",
+		Prefixes: []string{"openai", "gpt-", "chatgpt-", "o1"},
+		Credential: &Credential{
+			EnvVar:     "OPENAI_API_KEY",
+			ConfigKey:  "openai_api_key",
+			FromConfig: func(cfg *config.Config) string { return cfg.OpenAIKey },
+		},
+		New: func(cfg *config.Config, apiKey, model string) (Provider, error) {
+			opts, err := openAIHTTPOptions(cfg)
+			if err != nil {
+				return nil, err
+			}
+			var provider *OpenAIProvider
+			if cfg.OpenAIURL != "" {
+				provider, err = NewOpenAICompatibleProvider("openai", cfg.OpenAIURL, apiKey, nil, model, opts...)
+			} else {
+				provider, err = NewOpenAIProvider(apiKey, model, opts...)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return provider, nil
+		},
+	})
+}
+
 type OpenAIProvider struct {
 	client *openai.Client
+	name   string
 	model  string
+	// compatible is set for third-party servers, which mostly only know the
+	// legacy max_tokens field
+	compatible bool
+	// jsonSchema is set for servers known to accept a JSON Schema response
+	// format. Many compatible servers reject it, so they are left to the
+	// prompt.
+	jsonSchema bool
+}
+
+// openAIHTTPOptions makes the SDK use the configured transport and the
+// shared retry layer instead of its own
+func openAIHTTPOptions(cfg *config.Config) ([]option.RequestOption, error) {
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return []option.RequestOption{
+		option.WithHTTPClient(client),
+		option.WithMaxRetries(0),
+	}, nil
 }
 
-func NewOpenAIProvider(apiKey string, model string) (*OpenAIProvider, error) {
-	client := openai.NewClient(option.WithAPIKey(apiKey))
+// NewOpenAIProvider creates a provider for the OpenAI API. opts are passed on
+// to the SDK client.
+func NewOpenAIProvider(apiKey string, model string, opts ...option.RequestOption) (*OpenAIProvider, error) {
+	client := openai.NewClient(append([]option.RequestOption{option.WithAPIKey(apiKey)}, opts...)...)
+	return &OpenAIProvider{
+		client:     client,
+		name:       "openai",
+		model:      model,
+		jsonSchema: true,
+	}, nil
+}
+
+// NewOpenAICompatibleProvider creates a provider for any server implementing
+// the OpenAI chat completions API, such as vLLM, llama.cpp server or LM Studio.
+// name is reported by Name, headers are sent with every request and opts are
+// passed on to the SDK client.
+func NewOpenAICompatibleProvider(name, baseURL, apiKey string, headers map[string]string, model string, opts ...option.RequestOption) (*OpenAIProvider, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("no base URL configured for %s", name)
+	}
+
+	clientOpts := []option.RequestOption{option.WithBaseURL(baseURL)}
+	if apiKey != "" {
+		clientOpts = append(clientOpts, option.WithAPIKey(apiKey))
+	} else {
+		// The SDK picks up OPENAI_API_KEY by default, which must not leak to
+		// third-party servers
+		clientOpts = append(clientOpts, option.WithHeaderDel("Authorization"))
+	}
+	for key, value := range headers {
+		clientOpts = append(clientOpts, option.WithHeader(key, value))
+	}
+	clientOpts = append(clientOpts, opts...)
+
 	return &OpenAIProvider{
-		client: client,
-		model:  model,
+		client:     openai.NewClient(clientOpts...),
+		name:       name,
+		model:      model,
+		compatible: true,
 	}, nil
 }
 
-func (p *OpenAIProvider) Complete(ctx context.Context, prompt string, stream bool) (io.ReadCloser, error) {
-	if stream {
-		return p.streamCompletion(ctx, prompt)
+func (p *OpenAIProvider) Complete(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	if err := checkVision(p.model, req); err != nil {
+		return nil, err
+	}
+	if req.Stream {
+		return p.streamCompletion(ctx, req)
 	}
-	return p.completion(ctx, prompt)
+	return p.completion(ctx, req)
 }
 
 func (p *OpenAIProvider) Name() string {
-	return "openai"
+	return p.name
 }
 
-func (p *OpenAIProvider) streamCompletion(ctx context.Context, prompt string) (io.ReadCloser, error) {
-	var output strings.Builder
+// openAIMessages converts a request into OpenAI chat messages
+func openAIMessages(req *Request) []openai.ChatCompletionMessageParamUnion {
+	var messages []openai.ChatCompletionMessageParamUnion
+	if system := req.SystemPrompt(); system != "" {
+		messages = append(messages, openai.SystemMessage(system))
+	}
+	for _, msg := range req.Turns() {
+		switch {
+		case msg.Role == chat.RoleAssistant && len(msg.ToolCalls) > 0:
+			messages = append(messages, openAIToolCallMessage(msg))
+		case msg.Role == chat.RoleAssistant:
+			messages = append(messages, openai.AssistantMessage(msg.Content))
+		case msg.Role == chat.RoleTool:
+			messages = append(messages, openai.ToolMessage(msg.ToolCallID, msg.Content))
+		case len(msg.Images) > 0:
+			parts := []openai.ChatCompletionContentPartUnionParam{openai.TextPart(msg.Content)}
+			for _, image := range msg.Images {
+				parts = append(parts, openai.ImagePart(imageDataURL(image)))
+			}
+			messages = append(messages, openai.UserMessageParts(parts...))
+		default:
+			messages = append(messages, openai.UserMessage(msg.Content))
+		}
+	}
+	return messages
+}
 
-	stream := p.client.Chat.Completions.NewStreaming(ctx, openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		}),
-		Model: openai.F(p.model),
-	})
+// openAIToolCallMessage converts an assistant message calling tools
+func openAIToolCallMessage(msg chat.Message) openai.ChatCompletionAssistantMessageParam {
+	calls := make([]openai.ChatCompletionMessageToolCallParam, 0, len(msg.ToolCalls))
+	for _, call := range msg.ToolCalls {
+		calls = append(calls, openai.ChatCompletionMessageToolCallParam{
+			ID:   openai.F(call.ID),
+			Type: openai.F(openai.ChatCompletionMessageToolCallTypeFunction),
+			Function: openai.F(openai.ChatCompletionMessageToolCallFunctionParam{
+				Name:      openai.F(call.Name),
+				Arguments: openai.F(call.Arguments),
+			}),
+		})
+	}
 
-	for stream.Next() {
-		evt := stream.Current()
-		if len(evt.Choices) > 0 {
-			output.WriteString(evt.Choices[0].Delta.Content)
+	message := openai.ChatCompletionAssistantMessageParam{
+		Role:      openai.F(openai.ChatCompletionAssistantMessageParamRoleAssistant),
+		ToolCalls: openai.F(calls),
+	}
+	if msg.Content != "" {
+		message.Content = openai.F([]openai.ChatCompletionAssistantMessageParamContentUnion{openai.TextPart(msg.Content)})
+	}
+	return message
+}
+
+// openAITools converts tools into OpenAI function tools
+func openAITools(tools []Tool) []openai.ChatCompletionToolParam {
+	params := make([]openai.ChatCompletionToolParam, 0, len(tools))
+	for _, tool := range tools {
+		params = append(params, openai.ChatCompletionToolParam{
+			Type: openai.F(openai.ChatCompletionToolTypeFunction),
+			Function: openai.F(openai.FunctionDefinitionParam{
+				Name:        openai.F(tool.Name),
+				Description: openai.F(tool.Description),
+				Parameters:  openai.F(openai.FunctionParameters(tool.schema())),
+			}),
+		})
+	}
+	return params
+}
+
+// params builds the chat completion parameters for a request
+func (p *OpenAIProvider) params(req *Request) openai.ChatCompletionNewParams {
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F(openAIMessages(req)),
+		Model:    openai.F(p.model),
+	}
+
+	opts := req.Options
+	if opts.Temperature != nil {
+		params.Temperature = openai.F(*opts.Temperature)
+	}
+	if opts.MaxTokens != nil {
+		if p.compatible {
+			params.MaxTokens = openai.F(int64(*opts.MaxTokens))
+		} else {
+			params.MaxCompletionTokens = openai.F(int64(*opts.MaxTokens))
 		}
 	}
+	if opts.TopP != nil {
+		params.TopP = openai.F(*opts.TopP)
+	}
+	if len(opts.Stop) > 0 {
+		params.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](openai.ChatCompletionNewParamsStopArray(opts.Stop))
+	}
+	if opts.Seed != nil {
+		params.Seed = openai.F(int64(*opts.Seed))
+	}
+	if len(req.Tools) > 0 {
+		params.Tools = openai.F(openAITools(req.Tools))
+	}
+	if req.Schema != nil && p.jsonSchema {
+		// Strict mode only supports a subset of JSON Schema, so the answer
+		// is validated by the caller instead
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](openai.ResponseFormatJSONSchemaParam{
+			Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   openai.F("response"),
+				Schema: openai.F[interface{}](req.Schema),
+				Strict: openai.F(false),
+			}),
+		})
+	}
+
+	return params
+}
+
+// openAIUsage converts the API usage into Usage
+func openAIUsage(u *Usage, usage openai.CompletionUsage) {
+	u.InputTokens = int(usage.PromptTokens)
+	u.OutputTokens = int(usage.CompletionTokens)
+	u.CachedTokens = int(usage.PromptTokensDetails.CachedTokens)
+}
 
-	if err := stream.Err(); err != nil {
-		return nil, fmt.Errorf("stream completion error: %w", err)
+func (p *OpenAIProvider) streamCompletion(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	start := time.Now()
+	params := p.params(req)
+	if !p.compatible {
+		// Third-party servers may reject stream options they don't know
+		params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{
+			IncludeUsage: openai.F(true),
+		})
 	}
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params)
+
+	reader, writer := io.Pipe()
+	resp := newResponse(reader, p.model, start)
 
-	return io.NopCloser(strings.NewReader(output.String())), nil
+	go func() {
+		defer closeOnCancel(ctx, writer)()
+		// A request that failed before the stream opened leaves nothing to
+		// close
+		if stream.Err() == nil {
+			defer stream.Close()
+		}
+		for stream.Next() {
+			evt := stream.Current()
+			resp.update(func(u *Usage) {
+				if evt.Model != "" {
+					u.Model = evt.Model
+				}
+				// The usage arrives in a final chunk without choices
+				if evt.Usage.PromptTokens > 0 || evt.Usage.CompletionTokens > 0 {
+					openAIUsage(u, evt.Usage)
+				}
+				if len(evt.Choices) > 0 && evt.Choices[0].FinishReason != "" {
+					u.FinishReason = string(evt.Choices[0].FinishReason)
+				}
+			})
+			if len(evt.Choices) == 0 || evt.Choices[0].Delta.Content == "" {
+				continue
+			}
+			// A failed write means the reader was closed, so stop consuming
+			if _, err := writer.Write([]byte(evt.Choices[0].Delta.Content)); err != nil {
+				return
+			}
+		}
+
+		if err := stream.Err(); err != nil {
+			writer.CloseWithError(fmt.Errorf("stream completion error: %w", err))
+			return
+		}
+		if err := ctx.Err(); err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		writer.Close()
+	}()
+
+	return resp, nil
 }
 
-func (p *OpenAIProvider) completion(ctx context.Context, prompt string) (io.ReadCloser, error) {
-	completion, err := p.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		}),
-		Model: openai.F(p.model),
+func (p *OpenAIProvider) completion(ctx context.Context, req *Request) (io.ReadCloser, error) {
+	start := time.Now()
+	completion, err := p.client.Chat.Completions.New(ctx, p.params(req))
+	if err != nil {
+		return nil, fmt.Errorf("completion error: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return nil, fmt.Errorf("no completion choices returned")
+	}
+
+	resp := newResponse(io.NopCloser(strings.NewReader(completion.Choices[0].Message.Content)), p.model, start)
+	resp.update(func(u *Usage) {
+		if completion.Model != "" {
+			u.Model = completion.Model
+		}
+		openAIUsage(u, completion.Usage)
+		u.FinishReason = string(completion.Choices[0].FinishReason)
 	})
+	return resp, nil
+}
+
+func (p *OpenAIProvider) CompleteWithTools(ctx context.Context, req *Request) (*ToolResponse, error) {
+	if err := checkVision(p.model, req); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	completion, err := p.client.Chat.Completions.New(ctx, p.params(req))
 	if err != nil {
 		return nil, fmt.Errorf("completion error: %w", err)
 	}
+	if len(completion.Choices) == 0 {
+		return nil, fmt.Errorf("no completion choices returned")
+	}
 
-	return io.NopCloser(strings.NewReader(completion.Choices[0].Message.Content)), nil
+	message := completion.Choices[0].Message
+	resp := &ToolResponse{
+		Content: message.Content,
+		Usage: Usage{
+			Model:        p.model,
+			FinishReason: string(completion.Choices[0].FinishReason),
+			Latency:      time.Since(start),
+		},
+	}
+	if completion.Model != "" {
+		resp.Usage.Model = completion.Model
+	}
+	openAIUsage(&resp.Usage, completion.Usage)
+	for _, call := range message.ToolCalls {
+		resp.ToolCalls = append(resp.ToolCalls, chat.ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	return resp, nil
 }
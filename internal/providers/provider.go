@@ -4,9 +4,81 @@ package providers
 import (
 	"context"
 	"io"
+	"strings"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/pkg/chat"
 )
 
+// Request is a provider-agnostic chat request. Each provider maps it onto
+// its native chat format.
+type Request struct {
+	// System is the system prompt. Messages with the system role are
+	// merged into it by SystemPrompt.
+	System   string
+	Messages []chat.Message
+	Stream   bool
+	// Options are the generation parameters, mapped to each API's native fields
+	Options config.GenerationOptions
+	// Tools are the tools the model may call, used by CompleteWithTools
+	Tools []Tool
+	// Schema, if set, is the JSON Schema the answer must match. Providers
+	// with a native JSON mode enable it, the others rely on the prompt.
+	Schema map[string]interface{}
+	// ShowUsage makes ProcessRequest print the token usage to stderr after
+	// the answer
+	ShowUsage bool
+	// Pricing, if known, adds a cost estimate to the usage
+	Pricing *config.Pricing
+	// OnUsage, if set, is called by ProcessRequest with the usage once the
+	// answer is complete or interrupted
+	OnUsage func(Usage)
+	// BeforeAttempt, if set, is called by a fallback chain with the model ID
+	// and provider name of each model before the request is sent to it. An
+	// error skips the model.
+	BeforeAttempt func(modelID, provider string) error
+	// OnAnswer, if set, is called by ProcessRequest with the text read from
+	// the provider, also when an error or cancellation cut it short
+	OnAnswer func(answer string, err error)
+}
+
+// NewRequest creates a single-turn request from a user prompt
+func NewRequest(prompt string, stream bool) *Request {
+	return &Request{
+		Messages: []chat.Message{
+			{Role: chat.RoleUser, Content: prompt},
+		},
+		Stream: stream,
+	}
+}
+
+// SystemPrompt returns the system prompt followed by the content of any
+// system-role messages
+func (r *Request) SystemPrompt() string {
+	var parts []string
+	if r.System != "" {
+		parts = append(parts, r.System)
+	}
+	for _, msg := range r.Messages {
+		if msg.Role == chat.RoleSystem && msg.Content != "" {
+			parts = append(parts, msg.Content)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Turns returns the conversation messages without system-role messages
+func (r *Request) Turns() []chat.Message {
+	turns := make([]chat.Message, 0, len(r.Messages))
+	for _, msg := range r.Messages {
+		if msg.Role != chat.RoleSystem {
+			turns = append(turns, msg)
+		}
+	}
+	return turns
+}
+
 type Provider interface {
-	Complete(ctx context.Context, prompt string, stream bool) (io.ReadCloser, error)
+	Complete(ctx context.Context, req *Request) (io.ReadCloser, error)
 	Name() string
 }
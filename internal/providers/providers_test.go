@@ -2,7 +2,23 @@
 package providers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/pkg/chat"
+	"github.com/google/generative-ai-go/genai"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
 )
 
 func TestOpenAIProvider(t *testing.T) {
@@ -21,3 +37,951 @@ func TestAnthropicProvider(t *testing.T) {
 		t.Errorf("expected provider name anthropic, got %s", provider.Name())
 	}
 }
+
+func TestNewRequest(t *testing.T) {
+	req := NewRequest("hello", true)
+	if !req.Stream {
+		t.Errorf("expected stream to be enabled")
+	}
+	if len(req.Messages) != 1 || req.Messages[0].Role != chat.RoleUser || req.Messages[0].Content != "hello" {
+		t.Errorf("unexpected messages: %+v", req.Messages)
+	}
+}
+
+func TestRequestSystemPromptAndTurns(t *testing.T) {
+	req := &Request{
+		System: "be brief",
+		Messages: []chat.Message{
+			{Role: chat.RoleSystem, Content: "answer in english"},
+			{Role: chat.RoleUser, Content: "hi"},
+			{Role: chat.RoleAssistant, Content: "hello"},
+			{Role: chat.RoleUser, Content: "how are you?"},
+		},
+	}
+
+	if got, want := req.SystemPrompt(), "be brief\n\nanswer in english"; got != want {
+		t.Errorf("expected system prompt %q, got %q", want, got)
+	}
+
+	turns := req.Turns()
+	if len(turns) != 3 {
+		t.Fatalf("expected 3 turns, got %d", len(turns))
+	}
+	if turns[1].Role != chat.RoleAssistant {
+		t.Errorf("expected second turn to be from the assistant, got %s", turns[1].Role)
+	}
+}
+
+func TestOpenAIMessages(t *testing.T) {
+	req := &Request{
+		System: "be brief",
+		Messages: []chat.Message{
+			{Role: chat.RoleUser, Content: "hi"},
+			{Role: chat.RoleAssistant, Content: "hello"},
+		},
+	}
+	if got := len(openAIMessages(req)); got != 3 {
+		t.Errorf("expected 3 messages, got %d", got)
+	}
+}
+
+func TestGeminiContents(t *testing.T) {
+	req := &Request{
+		Messages: []chat.Message{
+			{Role: chat.RoleUser, Content: "hi"},
+			{Role: chat.RoleAssistant, Content: "hello"},
+		},
+	}
+	contents := geminiContents(req)
+	if len(contents) != 2 {
+		t.Fatalf("expected 2 contents, got %d", len(contents))
+	}
+	if contents[1].Role != "model" {
+		t.Errorf("expected assistant turn to map to model role, got %s", contents[1].Role)
+	}
+}
+
+func TestOpenAIStreamCompletionIsIncremental(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+
+		// Hold the rest of the stream until the first token was read
+		<-release
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\" world\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	provider := &OpenAIProvider{
+		client: openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL)),
+		name:   "openai",
+		model:  "gpt-4",
+	}
+
+	reader, err := provider.Complete(context.Background(), NewRequest("hi", true))
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+	defer reader.Close()
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatalf("failed to read first token: %v", err)
+	}
+	if string(buf) != "Hello" {
+		t.Errorf("expected first token Hello, got %q", buf)
+	}
+	close(release)
+
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read rest of stream: %v", err)
+	}
+	if string(rest) != " world" {
+		t.Errorf("expected rest of stream %q, got %q", " world", rest)
+	}
+}
+
+func TestOpenAIStreamCompletionPropagatesErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"invalid key"}}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	provider := &OpenAIProvider{
+		client: openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0)),
+		name:   "openai",
+		model:  "gpt-4",
+	}
+
+	reader, err := provider.Complete(context.Background(), NewRequest("hi", true))
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+	defer reader.Close()
+
+	if _, err := io.ReadAll(reader); err == nil {
+		t.Error("expected stream error to be propagated to the reader")
+	}
+}
+
+func TestSSEReader(t *testing.T) {
+	body := io.NopCloser(strings.NewReader(": keep-alive\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"ls\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\" -la\"},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: {\"model\":\"llama3-8b-8192\",\"choices\":[],\"x_groq\":{\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":3}}}\n\n" +
+		"data: [DONE]\n\n"))
+
+	reader := newSSEReader(body, "groq-llama3", time.Now())
+	defer reader.Close()
+
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+	if string(text) != "ls -la" {
+		t.Errorf("expected %q, got %q", "ls -la", text)
+	}
+
+	usage := reader.(UsageReporter).Usage()
+	if usage.Model != "llama3-8b-8192" || usage.InputTokens != 12 || usage.OutputTokens != 3 || usage.FinishReason != "stop" {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestDecodeChatCompletion(t *testing.T) {
+	body := io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"ls -la"},"finish_reason":"stop"}],` +
+		`"usage":{"prompt_tokens":20,"completion_tokens":4,"prompt_tokens_details":{"cached_tokens":8}}}`))
+
+	reader, err := decodeChatCompletion(body, "gpt-4o", time.Now())
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	text, _ := io.ReadAll(reader)
+	if string(text) != "ls -la" {
+		t.Errorf("expected %q, got %q", "ls -la", text)
+	}
+
+	usage := reader.(UsageReporter).Usage()
+	if usage.Model != "gpt-4o" || usage.InputTokens != 20 || usage.OutputTokens != 4 || usage.CachedTokens != 8 || usage.FinishReason != "stop" {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestOllamaProviderStreamsThroughReader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("expected /api/chat, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"model":"qwen2.5","message":{"role":"assistant","content":"Hello"},"done":false}`)
+		fmt.Fprintln(w, `{"model":"qwen2.5","message":{"role":"assistant","content":" world"},"done":false}`)
+		fmt.Fprintln(w, `{"model":"qwen2.5","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":9,"eval_count":2}`)
+	}))
+	defer server.Close()
+
+	provider := NewOllamaProvider(server.URL, "qwen2.5")
+	reader, err := provider.Complete(context.Background(), NewRequest("hi", true))
+	if err != nil {
+		t.Fatalf("failed to complete request: %v", err)
+	}
+	defer reader.Close()
+
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+	if string(text) != "Hello world" {
+		t.Errorf("expected %q, got %q", "Hello world", text)
+	}
+
+	usage := reader.(UsageReporter).Usage()
+	if usage.InputTokens != 9 || usage.OutputTokens != 2 || usage.FinishReason != "stop" {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestOllamaHost(t *testing.T) {
+	t.Setenv("OLLAMA_HOST", "10.0.0.2:11434")
+
+	if got := ollamaHost(&config.Config{OllamaHost: "http://ollama:11434"}); got != "http://ollama:11434" {
+		t.Errorf("expected configured host, got %s", got)
+	}
+	if got := ollamaHost(&config.Config{}); got != "http://10.0.0.2:11434" {
+		t.Errorf("expected host from environment, got %s", got)
+	}
+}
+
+func TestParseGeminiSafetySettings(t *testing.T) {
+	settings, err := parseGeminiSafetySettings(map[string]string{"harassment": "block_none"})
+	if err != nil {
+		t.Fatalf("failed to parse safety settings: %v", err)
+	}
+	if len(settings) != 1 || settings[0].Category != genai.HarmCategoryHarassment || settings[0].Threshold != genai.HarmBlockNone {
+		t.Errorf("unexpected safety settings: %+v", settings)
+	}
+
+	if _, err := parseGeminiSafetySettings(map[string]string{"harassment": "sometimes"}); err == nil {
+		t.Error("expected error for unknown threshold")
+	}
+}
+
+func TestGeminiText(t *testing.T) {
+	resp := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			Content: &genai.Content{Parts: []genai.Part{
+				genai.Text("Hello"),
+				genai.Blob{MIMEType: "image/png"},
+				genai.Text(" world"),
+			}},
+			FinishReason: genai.FinishReasonStop,
+		}},
+	}
+	text, err := geminiText(resp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text != "Hello world" {
+		t.Errorf("expected %q, got %q", "Hello world", text)
+	}
+
+	resp.Candidates[0].FinishReason = genai.FinishReasonSafety
+	if _, err := geminiText(resp); ExitCode(err) != exitCodes[KindContentFiltered] {
+		t.Errorf("expected content filtered error for safety finish reason, got %v", err)
+	}
+}
+
+func TestOpenAICompatibleProvider(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-should-not-leak")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("expected no Authorization header, got %q", auth)
+		}
+		if got := r.Header.Get("X-Team"); got != "shell" {
+			t.Errorf("expected X-Team header shell, got %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"hi there"}}]}`)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Endpoints: map[string]config.Endpoint{
+			"local": {BaseURL: server.URL, Headers: map[string]string{"X-Team": "shell"}},
+		},
+	}
+	provider, err := InitializeProviderWithConfig(cfg, "local/qwen2.5")
+	if err != nil {
+		t.Fatalf("failed to initialize provider: %v", err)
+	}
+	if provider.Name() != "local" {
+		t.Errorf("expected provider name local, got %s", provider.Name())
+	}
+
+	reader, err := provider.Complete(context.Background(), NewRequest("hi", false))
+	if err != nil {
+		t.Fatalf("failed to complete request: %v", err)
+	}
+	defer reader.Close()
+
+	text, _ := io.ReadAll(reader)
+	if string(text) != "hi there" {
+		t.Errorf("expected %q, got %q", "hi there", text)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	cfg := &config.Config{}
+	tests := []struct {
+		modelID  string
+		provider string
+		model    string
+	}{
+		{"gpt-4o", "openai", "gpt-4o"},
+		{"claude-3-haiku", "anthropic", "claude-3-haiku-20240307"},
+		{"anthropic/claude-3-5-sonnet-latest", "anthropic", "claude-3-5-sonnet-latest"},
+		{"groq-llama3", "groq", "llama3-70b-8192"},
+		{"qwen2.5:7b", "ollama", "qwen2.5:7b"},
+		{"ollama/qwen2.5:7b", "ollama", "qwen2.5:7b"},
+		{"copilot-gpt-4o", "copilot", "copilot-gpt-4o"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.modelID, func(t *testing.T) {
+			registration, model, err := Resolve(cfg, tt.modelID)
+			if err != nil {
+				t.Fatalf("Resolve(%q) error = %v", tt.modelID, err)
+			}
+			if registration.Name != tt.provider || model != tt.model {
+				t.Errorf("Resolve(%q) = %s, %s, want %s, %s", tt.modelID, registration.Name, model, tt.provider, tt.model)
+			}
+		})
+	}
+
+	if _, _, err := Resolve(cfg, "unknown/model"); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
+
+func TestInitializeProviderMissingCredential(t *testing.T) {
+	t.Setenv("GROQ_API_KEY", "")
+
+	_, err := InitializeProviderWithConfig(&config.Config{}, "groq/llama3-8b-8192")
+	var providerErr *Error
+	if !errors.As(err, &providerErr) || providerErr.Kind != KindMissingKey || !strings.Contains(providerErr.Hint, "GROQ_API_KEY") {
+		t.Errorf("expected missing key error with a hint mentioning GROQ_API_KEY, got %v", err)
+	}
+
+	provider, err := InitializeProviderWithConfig(&config.Config{GroqKey: "test-key"}, "groq/llama3-8b-8192")
+	if err != nil {
+		t.Fatalf("expected key from config to be used, got %v", err)
+	}
+	if provider.Name() != "groq" {
+		t.Errorf("expected provider name groq, got %s", provider.Name())
+	}
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected Register to panic for a duplicate name")
+		}
+	}()
+	Register(Registration{
+		Name: "openai",
+		New: func(cfg *config.Config, apiKey, model string) (Provider, error) {
+			return nil, nil
+		},
+	})
+}
+
+func TestOpenAIParamsOptions(t *testing.T) {
+	temperature, maxTokens := 0.3, 256
+	req := NewRequest("hi", false)
+	req.Options = config.GenerationOptions{Temperature: &temperature, MaxTokens: &maxTokens, Stop: []string{"END"}}
+
+	provider, _ := NewOpenAIProvider("test-key", "gpt-4o")
+	params := provider.params(req)
+	if params.Temperature.Value != 0.3 {
+		t.Errorf("expected temperature 0.3, got %v", params.Temperature.Value)
+	}
+	if params.MaxCompletionTokens.Value != 256 || params.MaxTokens.Present {
+		t.Errorf("expected max_completion_tokens for OpenAI, got %+v / %+v", params.MaxCompletionTokens, params.MaxTokens)
+	}
+	if !params.Stop.Present || params.TopP.Present {
+		t.Errorf("expected only the configured fields to be set")
+	}
+
+	compatible, _ := NewOpenAICompatibleProvider("local", "http://localhost:8000/v1", "", nil, "qwen2.5")
+	if params := compatible.params(req); params.MaxTokens.Value != 256 {
+		t.Errorf("expected max_tokens for compatible servers, got %+v", params.MaxTokens)
+	}
+}
+
+func TestOpenAIResponseFormat(t *testing.T) {
+	req := NewRequest("hi", false)
+	req.Schema = map[string]interface{}{"type": "object"}
+
+	provider, _ := NewOpenAIProvider("test-key", "gpt-4o")
+	if params := provider.params(req); !params.ResponseFormat.Present {
+		t.Error("expected the JSON Schema response format for OpenAI")
+	}
+
+	// Compatible servers only get it when configured to accept it
+	compatible, _ := NewOpenAICompatibleProvider("local", "http://localhost:8000/v1", "", nil, "qwen2.5")
+	if params := compatible.params(req); params.ResponseFormat.Present {
+		t.Error("expected no response format for a compatible server")
+	}
+	registration := endpointRegistration("vllm", config.Endpoint{BaseURL: "http://localhost:8000/v1", JSONSchema: true})
+	configured, err := registration.New(&config.Config{}, "", "qwen2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params := configured.(*OpenAIProvider).params(req); !params.ResponseFormat.Present {
+		t.Error("expected the response format for an endpoint accepting it")
+	}
+}
+
+func TestOllamaOptions(t *testing.T) {
+	maxTokens, seed := 128, 7
+	options := ollamaOptions(config.GenerationOptions{MaxTokens: &maxTokens, Seed: &seed})
+	if options["num_predict"] != 128 || options["seed"] != 7 {
+		t.Errorf("unexpected ollama options: %v", options)
+	}
+	if _, ok := options["temperature"]; ok {
+		t.Errorf("expected unset temperature to be omitted")
+	}
+}
+
+func TestFormatUsage(t *testing.T) {
+	usage := Usage{
+		Model:        "gpt-4o-2024-08-06",
+		InputTokens:  120,
+		OutputTokens: 30,
+		CachedTokens: 64,
+		FinishReason: "stop",
+		Latency:      1234567 * time.Microsecond,
+	}
+	want := "gpt-4o-2024-08-06: 120 input, 30 output tokens, 64 cached, finish: stop, 1.235s"
+	if got := FormatUsage(usage, nil); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	pricing := &config.Pricing{Input: 2.5, Output: 10}
+	want = "gpt-4o-2024-08-06: 120 input, 30 output tokens, 64 cached, finish: stop, ~$0.0006, 1.235s"
+	if got := FormatUsage(usage, pricing); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Errorf("expected the body to be resent, got %q", body)
+		}
+		if attempts < 3 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(529)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	transport := newRetryTransport(http.DefaultTransport, 2)
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || attempts != 3 {
+		t.Errorf("expected success after 3 attempts, got %s after %d", resp.Status, attempts)
+	}
+	if len(delays) != 2 || delays[0] != 2*time.Second {
+		t.Errorf("expected two waits of the Retry-After duration, got %v", delays)
+	}
+
+	// Out of retries, the last response is returned
+	attempts = 0
+	transport.retries = 1
+	resp, err = client.Post(server.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 529 || attempts != 2 {
+		t.Errorf("expected 529 after 2 attempts, got %s after %d", resp.Status, attempts)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.Header.Get("x-api-key") != "key" {
+			t.Errorf("expected the API key, got %q", r.Header.Get("x-api-key"))
+		}
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	resp, err := NewClient("key", server.URL).Post(context.Background(), "/v1/messages", map[string]string{"prompt": "hi"})
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if attempts != 2 {
+		t.Errorf("expected the rate-limited request to be retried, got %d attempts", attempts)
+	}
+
+	client, err := NewClientWithConfig(&config.Config{Retries: 0}, "key", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts = 0
+	if _, err := client.Post(context.Background(), "/v1/messages", nil); err == nil || attempts != 1 {
+		t.Errorf("expected a single attempt without retries, got %d: %v", attempts, err)
+	}
+}
+
+func TestRetryTransportSkipsClientErrors(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newRetryTransport(http.DefaultTransport, 3)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if attempts != 1 {
+		t.Errorf("expected a single attempt for a 400, got %d", attempts)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{"seconds", http.Header{"Retry-After": {"3"}}, 3 * time.Second, true},
+		{"milliseconds", http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"1"}}, 250 * time.Millisecond, true},
+		{"date", http.Header{"Retry-After": {now.Add(5 * time.Second).Format(http.TimeFormat)}}, 5 * time.Second, true},
+		{"missing", http.Header{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.header, now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	header := http.Header{
+		"X-Ratelimit-Reset-Requests":         {"1.5s"},
+		"X-Ratelimit-Reset-Tokens":           {"6s"},
+		"Anthropic-Ratelimit-Requests-Reset": {now.Add(4 * time.Second).Format(time.RFC3339)},
+	}
+	if got, ok := rateLimitReset(header, now); !ok || got != 6*time.Second {
+		t.Errorf("expected the latest rate limit reset of 6s, got %v, %v", got, ok)
+	}
+}
+
+func TestFallbackProvider(t *testing.T) {
+	overloaded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusServiceUnavailable)
+	}))
+	defer overloaded.Close()
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"bad request"}}`, http.StatusBadRequest)
+	}))
+	defer invalid.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi there\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer working.Close()
+
+	cfg := &config.Config{
+		Endpoints: map[string]config.Endpoint{
+			"overloaded": {BaseURL: overloaded.URL},
+			"invalid":    {BaseURL: invalid.URL},
+			"working":    {BaseURL: working.URL},
+		},
+		Fallbacks: map[string][]string{
+			"default": {"groq/llama3-8b-8192", "overloaded/qwen2.5", "working/qwen2.5"},
+			"strict":  {"invalid/qwen2.5", "working/qwen2.5"},
+		},
+	}
+	t.Setenv("GROQ_API_KEY", "")
+
+	provider, err := InitializeProviderWithConfig(cfg, "default")
+	if err != nil {
+		t.Fatalf("failed to initialize fallback chain: %v", err)
+	}
+	fallback := provider.(*FallbackProvider)
+	var log strings.Builder
+	fallback.log = &log
+
+	reader, err := provider.Complete(context.Background(), NewRequest("hi", true))
+	if err != nil {
+		t.Fatalf("expected the chain to fall back, got %v", err)
+	}
+	defer reader.Close()
+
+	text, _ := io.ReadAll(reader)
+	if string(text) != "hi there" {
+		t.Errorf("expected %q, got %q", "hi there", text)
+	}
+	if fallback.Answered() != "working/qwen2.5" || provider.Name() != "working" {
+		t.Errorf("expected working/qwen2.5 to answer, got %s (%s)", fallback.Answered(), provider.Name())
+	}
+	if !strings.Contains(log.String(), "Answered by working/qwen2.5") {
+		t.Errorf("expected the answering model on the log, got %q", log.String())
+	}
+
+	// A bad request would fail with every model
+	provider, _ = InitializeProviderWithConfig(cfg, "strict")
+	provider.(*FallbackProvider).log = io.Discard
+	if _, err := provider.Complete(context.Background(), NewRequest("hi", false)); err == nil {
+		t.Error("expected a bad request not to fall back")
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind ErrorKind
+	}{
+		{"unauthorized", &HTTPError{Provider: "groq", StatusCode: 401, Status: "401 Unauthorized"}, KindInvalidKey},
+		{"rate limited", &HTTPError{Provider: "groq", StatusCode: 429, Status: "429 Too Many Requests"}, KindRateLimited},
+		{"model not found", &HTTPError{Provider: "groq", StatusCode: 404, Status: "404 Not Found"}, KindModelNotFound},
+		{"overloaded", &HTTPError{Provider: "groq", StatusCode: 529, Status: "529"}, KindServer},
+		{"context too long", &HTTPError{Provider: "groq", StatusCode: 400, Status: "400 Bad Request",
+			Body: `{"error":{"code":"context_length_exceeded"}}`}, KindContextTooLong},
+		{"content filtered", &HTTPError{Provider: "groq", StatusCode: 400, Status: "400 Bad Request",
+			Body: `{"error":{"code":"content_filter"}}`}, KindContentFiltered},
+		{"timeout", fmt.Errorf("request failed: %w", context.DeadlineExceeded), KindNetwork},
+		{"dns", &net.DNSError{Err: "no such host", Name: "api.groq.com"}, KindNetwork},
+		{"bad request", &HTTPError{Provider: "groq", StatusCode: 400, Status: "400 Bad Request"}, KindUnknown},
+	}
+	for _, tt := range tests {
+		err := ClassifyError("groq", tt.err)
+		var providerErr *Error
+		if !errors.As(err, &providerErr) {
+			if tt.kind != KindUnknown {
+				t.Errorf("%s: expected kind %s, got unclassified %v", tt.name, tt.kind, err)
+			}
+			continue
+		}
+		if providerErr.Kind != tt.kind {
+			t.Errorf("%s: expected kind %s, got %s", tt.name, tt.kind, providerErr.Kind)
+		}
+		if providerErr.Hint == "" {
+			t.Errorf("%s: expected a hint", tt.name)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected the underlying error to be wrapped", tt.name)
+		}
+	}
+
+	if err := ClassifyError("groq", context.Canceled); err != context.Canceled {
+		t.Errorf("expected a cancelled request to stay unclassified, got %v", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	err := fmt.Errorf("failed to complete request: %w", newError(KindRateLimited, "openai", nil))
+	if code := ExitCode(err); code != 5 {
+		t.Errorf("expected exit code 5 for a rate limit, got %d", code)
+	}
+	if code := ExitCode(errors.New("boom")); code != 1 {
+		t.Errorf("expected exit code 1 for other errors, got %d", code)
+	}
+
+	// The codes are documented, so they must stay distinct
+	seen := make(map[int]ErrorKind)
+	for kind, code := range exitCodes {
+		if other, ok := seen[code]; ok {
+			t.Errorf("%s and %s share exit code %d", kind, other, code)
+		}
+		seen[code] = kind
+	}
+}
+
+func TestOpenAICompleteWithTools(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"name":"run_shell"`) {
+			t.Errorf("expected the tool in the request, got %s", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":null,
+			"tool_calls":[{"id":"call_1","type":"function","function":{"name":"run_shell","arguments":"{\"command\":\"uname\"}"}}]}}]}`)
+	}))
+	defer server.Close()
+
+	provider, _ := NewOpenAICompatibleProvider("local", server.URL, "", nil, "qwen2.5", option.WithMaxRetries(0))
+	req := NewRequest("which OS?", false)
+	req.Tools = []Tool{{
+		Name:       "run_shell",
+		Parameters: []ToolParameter{{Name: "command", Type: "string", Required: true}},
+	}}
+
+	resp, err := provider.CompleteWithTools(context.Background(), req)
+	if err != nil {
+		t.Fatalf("failed to complete request: %v", err)
+	}
+	if len(resp.ToolCalls) != 1 {
+		t.Fatalf("expected 1 tool call, got %d", len(resp.ToolCalls))
+	}
+	call := resp.ToolCalls[0]
+	if call.ID != "call_1" || call.Name != "run_shell" || call.Arguments != `{"command":"uname"}` {
+		t.Errorf("unexpected tool call %+v", call)
+	}
+}
+
+func TestToolMessages(t *testing.T) {
+	req := &Request{
+		Messages: []chat.Message{
+			{Role: chat.RoleUser, Content: "which OS?"},
+			{Role: chat.RoleAssistant, ToolCalls: []chat.ToolCall{
+				{ID: "a", Name: "run_shell", Arguments: `{"command":"uname"}`},
+				{ID: "b", Name: "read_file", Arguments: `{"path":"/etc/os-release"}`},
+			}},
+			{Role: chat.RoleTool, ToolCallID: "a", Content: "Linux"},
+			{Role: chat.RoleTool, ToolCallID: "b", Content: "ID=debian"},
+		},
+	}
+
+	if got := len(openAIMessages(req)); got != 4 {
+		t.Errorf("expected 4 OpenAI messages, got %d", got)
+	}
+
+	// The results of one turn of tool calls are sent in one message
+	if got := len(anthropicMessages(req)); got != 3 {
+		t.Errorf("expected 3 Anthropic messages, got %d", got)
+	}
+
+	contents := geminiContents(req)
+	if len(contents) != 3 {
+		t.Fatalf("expected 3 Gemini contents, got %d", len(contents))
+	}
+	response, ok := contents[2].Parts[1].(genai.FunctionResponse)
+	if !ok || response.Name != "read_file" {
+		t.Errorf("expected a read_file function response, got %#v", contents[2].Parts[1])
+	}
+
+	messages := ollamaMessages(req)
+	if len(messages[1].ToolCalls) != 2 || messages[1].ToolCalls[0].Function.Arguments["command"] != "uname" {
+		t.Errorf("expected the tool calls to be passed to Ollama, got %+v", messages[1].ToolCalls)
+	}
+}
+
+func TestGeminiSchema(t *testing.T) {
+	schema, ok := geminiSchema(map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"tags": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"kind": map[string]interface{}{"type": "string", "enum": []interface{}{"file", "dir"}},
+		},
+		"required": []interface{}{"tags"},
+	})
+	if !ok {
+		t.Fatal("expected the schema to be converted")
+	}
+	if schema.Properties["tags"].Items.Type != genai.TypeString || len(schema.Properties["kind"].Enum) != 2 {
+		t.Errorf("unexpected schema %+v", schema)
+	}
+
+	if _, ok := geminiSchema(map[string]interface{}{"anyOf": []interface{}{}}); ok {
+		t.Error("expected anyOf not to be converted")
+	}
+}
+
+func TestImageMessages(t *testing.T) {
+	req := NewRequest("what is this error?", false)
+	req.Messages[0].Images = []chat.Image{{MIMEType: "image/png", Data: []byte("png")}}
+
+	messages := openAIMessages(req)
+	user, ok := messages[0].(openai.ChatCompletionUserMessageParam)
+	if !ok || len(user.Content.Value) != 2 {
+		t.Errorf("expected a text and an image part, got %#v", messages[0])
+	}
+	if got := imageDataURL(req.Messages[0].Images[0]); got != "data:image/png;base64,cG5n" {
+		t.Errorf("unexpected data URL %s", got)
+	}
+
+	contents := geminiContents(req)
+	if blob, ok := contents[0].Parts[1].(genai.Blob); !ok || blob.MIMEType != "image/png" {
+		t.Errorf("expected an image blob, got %#v", contents[0].Parts)
+	}
+	if images := ollamaMessages(req)[0].Images; len(images) != 1 {
+		t.Errorf("expected the image to be passed to Ollama, got %d images", len(images))
+	}
+
+	if err := checkVision("gpt-3.5-turbo", req); err == nil || !strings.Contains(err.Error(), "does not accept images") {
+		t.Errorf("expected a text-only model to be refused, got %v", err)
+	}
+	if err := checkVision("gpt-4o", req); err != nil {
+		t.Errorf("expected a vision model to be accepted, got %v", err)
+	}
+}
+
+func TestOllamaRefusesImagesWithoutVision(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/show" {
+			t.Errorf("expected the model to be checked first, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"modelfile":"","details":{"family":"qwen2"}}`)
+	}))
+	defer server.Close()
+
+	req := NewRequest("what is this?", false)
+	req.Messages[0].Images = []chat.Image{{MIMEType: "image/png", Data: []byte("png")}}
+	_, err := NewOllamaProvider(server.URL, "qwen2.5:7b").Complete(context.Background(), req)
+	if err == nil || !strings.Contains(err.Error(), "does not accept images") {
+		t.Errorf("expected a model without vision to be refused, got %v", err)
+	}
+}
+
+func TestMockProviderEcho(t *testing.T) {
+	provider, err := InitializeProviderWithConfig(&config.Config{}, "mock/echo")
+	if err != nil {
+		t.Fatalf("failed to initialize mock provider: %v", err)
+	}
+
+	reader, err := provider.Complete(context.Background(), NewRequest("hello mock world", true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+	text, err := io.ReadAll(reader)
+	if err != nil || string(text) != "hello mock world" {
+		t.Errorf("expected the prompt echoed, got %q, %v", text, err)
+	}
+	usage := reader.(UsageReporter).Usage()
+	if usage.Model != "echo" || usage.InputTokens == 0 || usage.OutputTokens == 0 {
+		t.Errorf("expected estimated usage, got %+v", usage)
+	}
+
+	provider, err = NewMockProvider("echo?error=rate_limited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Complete(context.Background(), NewRequest("hi", false))
+	var classified *Error
+	if !errors.As(err, &classified) || classified.Kind != KindRateLimited {
+		t.Errorf("expected an injected rate limit error, got %v", err)
+	}
+
+	if _, err := NewMockProvider("echo?latency=soon"); err == nil {
+		t.Error("expected an error for an invalid latency")
+	}
+}
+
+func TestMockProviderFixture(t *testing.T) {
+	path := t.TempDir() + "/fixture.json"
+	fixture := `{"responses": [
+		{"tool_calls": [{"name": "run_shell", "arguments": {"command": "df -h"}}]},
+		{"content": "The disk is full.", "usage": {"input_tokens": 40, "output_tokens": 5}}
+	]}`
+	if err := os.WriteFile(path, []byte(fixture), 0644); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewMockProvider("fixture:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := NewRequest("why is the disk full?", false)
+	resp, err := provider.CompleteWithTools(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "run_shell" || resp.ToolCalls[0].Arguments != `{"command": "df -h"}` {
+		t.Errorf("expected the run_shell call of the fixture, got %+v", resp.ToolCalls)
+	}
+
+	resp, err = provider.CompleteWithTools(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Content != "The disk is full." || resp.Usage.InputTokens != 40 || resp.Usage.OutputTokens != 5 {
+		t.Errorf("expected the second answer of the fixture, got %+v", resp)
+	}
+
+	// The last answer repeats
+	reader, err := provider.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+	if text, _ := io.ReadAll(reader); string(text) != "The disk is full." {
+		t.Errorf("expected the last answer again, got %q", text)
+	}
+}
+
+func TestProcessRequestKeepsInterruptedAnswer(t *testing.T) {
+	provider, err := NewMockProvider("echo?chunk_delay=50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout = stdout }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer time.AfterFunc(120*time.Millisecond, cancel).Stop()
+	req := NewRequest("one two three four five six seven eight", true)
+	var answer string
+	var answerErr error
+	req.OnAnswer = func(a string, err error) { answer, answerErr = a, err }
+	var usage *Usage
+	req.OnUsage = func(u Usage) { usage = &u }
+
+	err = ProcessRequest(ctx, provider, req)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation, got %v", err)
+	}
+	if !errors.Is(answerErr, context.Canceled) {
+		t.Errorf("expected OnAnswer to get the cancellation, got %v", answerErr)
+	}
+	if !strings.HasPrefix(answer, "one ") || strings.Contains(answer, "eight") {
+		t.Errorf("expected the partial answer, got %q", answer)
+	}
+	if usage == nil || usage.InputTokens == 0 {
+		t.Errorf("expected the usage of the partial answer, got %+v", usage)
+	}
+}
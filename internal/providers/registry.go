@@ -0,0 +1,129 @@
+// internal/providers/registry.go
+package providers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+)
+
+// Credential describes the API key a provider needs. It is read from the
+// environment first and from the config file second.
+type Credential struct {
+	// EnvVar is the environment variable holding the key
+	EnvVar string
+	// ConfigKey is the config file key holding the key
+	ConfigKey string
+	// FromConfig reads the key from the loaded config
+	FromConfig func(cfg *config.Config) string
+}
+
+// Value returns the credential, or an empty string if it is not set
+func (c Credential) Value(cfg *config.Config) string {
+	if c.EnvVar != "" {
+		if value := os.Getenv(c.EnvVar); value != "" {
+			return value
+		}
+	}
+	if c.FromConfig != nil && cfg != nil {
+		return c.FromConfig(cfg)
+	}
+	return ""
+}
+
+// Hint tells the user how to provide the credential
+func (c Credential) Hint() string {
+	switch {
+	case c.EnvVar != "" && c.ConfigKey != "":
+		return fmt.Sprintf("set %s or %s in the config file", c.EnvVar, c.ConfigKey)
+	case c.EnvVar != "":
+		return fmt.Sprintf("set %s", c.EnvVar)
+	default:
+		return fmt.Sprintf("set %s in the config file", c.ConfigKey)
+	}
+}
+
+// Registration describes a provider backend
+type Registration struct {
+	// Name is the provider prefix used in model IDs, as in name/model
+	Name string
+	// Prefixes select this provider for model IDs given without a provider
+	Prefixes []string
+	// Match optionally selects this provider for model IDs the prefixes miss
+	Match func(model string) bool
+	// Credential is the API key the provider requires, if any
+	Credential *Credential
+	// New creates the provider. apiKey is the resolved credential.
+	New func(cfg *config.Config, apiKey, model string) (Provider, error)
+}
+
+// MissingCredential returns the credential the provider requires but which
+// is not set, or nil if the provider is ready to use
+func (r Registration) MissingCredential(cfg *config.Config) *Credential {
+	if r.Credential == nil || r.Credential.Value(cfg) != "" {
+		return nil
+	}
+	return r.Credential
+}
+
+var registry []Registration
+
+// Register makes a provider available for model resolution. It panics if
+// a provider with the same name is already registered.
+func Register(r Registration) {
+	if r.Name == "" || r.New == nil {
+		panic("providers: Register requires a name and a constructor")
+	}
+	if _, ok := Lookup(r.Name); ok {
+		panic("providers: Register called twice for provider " + r.Name)
+	}
+	registry = append(registry, r)
+}
+
+// Lookup returns the provider registered under the given name
+func Lookup(name string) (Registration, bool) {
+	for _, r := range registry {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Registration{}, false
+}
+
+// Registered returns all registered providers sorted by name
+func Registered() []Registration {
+	registrations := make([]Registration, len(registry))
+	copy(registrations, registry)
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].Name < registrations[j].Name
+	})
+	return registrations
+}
+
+// Infer returns the provider serving a model ID given without a provider
+// prefix. The longest matching prefix wins; Match functions are consulted
+// when no prefix matches.
+func Infer(model string) (Registration, bool) {
+	var best Registration
+	bestLen := 0
+	for _, r := range registry {
+		for _, prefix := range r.Prefixes {
+			if strings.HasPrefix(model, prefix) && len(prefix) > bestLen {
+				best, bestLen = r, len(prefix)
+			}
+		}
+	}
+	if bestLen > 0 {
+		return best, true
+	}
+
+	for _, r := range Registered() {
+		if r.Match != nil && r.Match(model) {
+			return r, true
+		}
+	}
+	return Registration{}, false
+}
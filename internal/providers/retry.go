@@ -0,0 +1,197 @@
+// internal/providers/retry.go
+package providers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+	"github.com/acazau/shell-ask-go/internal/httpclient"
+)
+
+const (
+	// retryBaseDelay is the wait before the first retry, doubled per attempt
+	retryBaseDelay = 500 * time.Millisecond
+	// retryMaxDelay caps the exponential backoff
+	retryMaxDelay = 8 * time.Second
+	// retryMaxWait is the longest server-requested wait that is honored.
+	// Longer waits fail right away instead of hanging the shell.
+	retryMaxWait = time.Minute
+)
+
+// retryableStatus are the statuses of failures that are safe to retry as the
+// request was not processed. 529 is Anthropic's overloaded status.
+var retryableStatus = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+	529:                            true,
+}
+
+// rateLimitResetHeaders hold the time until a rate limit resets. OpenAI and
+// Groq send durations such as "6m0s", Anthropic sends RFC 3339 timestamps.
+var rateLimitResetHeaders = []string{
+	"x-ratelimit-reset-requests",
+	"x-ratelimit-reset-tokens",
+	"anthropic-ratelimit-requests-reset",
+	"anthropic-ratelimit-tokens-reset",
+	"anthropic-ratelimit-input-tokens-reset",
+	"anthropic-ratelimit-output-tokens-reset",
+}
+
+// retryTransport retries requests failing with a retryable status or before
+// the connection was established. Retries happen before the response body
+// is handed out, so a stream is never repeated once its first byte was read.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	// sleep waits for d or until ctx is done
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewHTTPClient returns an HTTP client for the configured transport,
+// retrying rate-limited and transient failures up to cfg.Retries times with
+// exponential backoff
+func NewHTTPClient(cfg *config.Config) (*http.Client, error) {
+	transport, err := httpclient.NewTransport(cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: newRetryTransport(transport, cfg.Retries)}, nil
+}
+
+func newRetryTransport(base http.RoundTripper, retries int) *retryTransport {
+	return &retryTransport{base: base, retries: retries, sleep: sleepContext}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			// The body was consumed by the previous attempt
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.retries || !t.canRetry(req) {
+			return resp, err
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if !isDialError(err) {
+				return nil, err
+			}
+			delay = backoff(attempt)
+		case retryableStatus[resp.StatusCode]:
+			wait, ok := retryAfter(resp.Header, time.Now())
+			if !ok && resp.StatusCode == http.StatusTooManyRequests {
+				wait, ok = rateLimitReset(resp.Header, time.Now())
+			}
+			if ok && wait > retryMaxWait {
+				return resp, nil
+			}
+			if !ok {
+				wait = backoff(attempt)
+			}
+			delay = wait
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		default:
+			return resp, nil
+		}
+
+		if err := t.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// canRetry reports whether the request body can be sent again
+func (t *retryTransport) canRetry(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// isDialError reports whether err happened while connecting, so the request
+// never reached the server
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// backoff returns the exponential delay before retry attempt+1, with jitter
+// so concurrent clients do not retry in lockstep
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter returns the wait the server asked for in Retry-After or
+// retry-after-ms
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second)), true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+	return 0, false
+}
+
+// rateLimitReset returns the time until the exhausted rate limit resets. As
+// the headers do not say which limit was hit, it waits for the one that
+// resets last.
+func rateLimitReset(header http.Header, now time.Time) (time.Duration, bool) {
+	var wait time.Duration
+	var found bool
+	for _, name := range rateLimitResetHeaders {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			reset, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				continue
+			}
+			d = reset.Sub(now)
+		}
+		wait = max(wait, d)
+		found = true
+	}
+	return wait, found
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -5,16 +5,155 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/acazau/shell-ask-go/pkg/stream"
 )
 
-func ProcessRequest(ctx context.Context, provider Provider, prompt string, stream bool) error {
-	reader, err := provider.Complete(ctx, prompt, stream)
+// chatCompletionUsage is the token usage reported by OpenAI-compatible APIs
+type chatCompletionUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// chatCompletionResponse is the non-streaming response body of
+// OpenAI-compatible chat completion APIs
+type chatCompletionResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatCompletionUsage `json:"usage"`
+}
+
+// chatCompletionChunk is the usage-related part of a streamed chunk. Groq
+// reports the usage of a stream under x_groq instead of usage.
+type chatCompletionChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatCompletionUsage `json:"usage"`
+	XGroq *struct {
+		Usage *chatCompletionUsage `json:"usage"`
+	} `json:"x_groq"`
+}
+
+// record copies the usage into u
+func (c *chatCompletionUsage) record(u *Usage) {
+	u.InputTokens = c.PromptTokens
+	u.OutputTokens = c.CompletionTokens
+	u.CachedTokens = c.PromptTokensDetails.CachedTokens
+}
+
+// decodeChatCompletion reads a non-streaming OpenAI-compatible response body
+// and returns the content of the first choice
+func decodeChatCompletion(body io.ReadCloser, model string, start time.Time) (io.ReadCloser, error) {
+	defer body.Close()
+
+	var response chatCompletionResponse
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("no completion choices returned")
+	}
+
+	resp := newResponse(io.NopCloser(strings.NewReader(response.Choices[0].Message.Content)), model, start)
+	resp.update(func(u *Usage) {
+		if response.Model != "" {
+			u.Model = response.Model
+		}
+		if response.Usage != nil {
+			response.Usage.record(u)
+		}
+		u.FinishReason = response.Choices[0].FinishReason
+	})
+	return resp, nil
+}
+
+// usageStreamProcessor decodes the text deltas of an OpenAI-compatible
+// stream and records the usage reported along the way
+type usageStreamProcessor struct {
+	stream.OpenAIStreamProcessor
+	resp *response
+}
+
+func (p *usageStreamProcessor) ProcessChunk(chunk []byte) (string, error) {
+	text, err := p.OpenAIStreamProcessor.ProcessChunk(chunk)
+	if err != nil {
+		return text, err
+	}
+
+	var evt chatCompletionChunk
+	if json.Unmarshal(bytes.TrimPrefix(chunk, []byte("data: ")), &evt) != nil {
+		return text, nil
+	}
+	p.resp.update(func(u *Usage) {
+		if evt.Model != "" {
+			u.Model = evt.Model
+		}
+		if len(evt.Choices) > 0 && evt.Choices[0].FinishReason != "" {
+			u.FinishReason = evt.Choices[0].FinishReason
+		}
+		if evt.Usage != nil {
+			evt.Usage.record(u)
+		} else if evt.XGroq != nil && evt.XGroq.Usage != nil {
+			evt.XGroq.Usage.record(u)
+		}
+	})
+	return text, nil
+}
+
+// sseReader yields the text deltas decoded from an SSE response body
+type sseReader struct {
+	*response
+	body io.Closer
+}
+
+func (r *sseReader) Close() error {
+	r.response.Close()
+	return r.body.Close()
+}
+
+// newSSEReader decodes an OpenAI-compatible SSE response body into a reader
+// of plain text deltas
+func newSSEReader(body io.ReadCloser, model string, start time.Time) io.ReadCloser {
+	reader, writer := io.Pipe()
+	resp := newResponse(reader, model, start)
+
+	go func() {
+		defer body.Close()
+		writer.CloseWithError(stream.ProcessStream(body, writer, &usageStreamProcessor{resp: resp}))
+	}()
+
+	return &sseReader{response: resp, body: body}
+}
+
+// closeOnCancel fails the pipe of an answer with the error of ctx once ctx
+// is done, so its reader ends at once even while the producer is blocked.
+// The returned function stops watching ctx.
+func closeOnCancel(ctx context.Context, writer *io.PipeWriter) func() bool {
+	return context.AfterFunc(ctx, func() { writer.CloseWithError(ctx.Err()) })
+}
+
+func ProcessRequest(ctx context.Context, provider Provider, req *Request) error {
+	reader, err := provider.Complete(ctx, req)
 	if err != nil {
-		return fmt.Errorf("failed to complete request: %w", err)
+		return fmt.Errorf("failed to complete request: %w", ClassifyError(provider.Name(), err))
 	}
 	defer func() {
 		if closer, ok := reader.(io.Closer); ok {
@@ -22,8 +161,36 @@ func ProcessRequest(ctx context.Context, provider Provider, prompt string, strea
 		}
 	}()
 
+	var answer strings.Builder
+	err = printAnswer(io.TeeReader(reader, &answer), req.Stream)
+	if req.OnAnswer != nil {
+		req.OnAnswer(answer.String(), err)
+	}
+	// The tokens of an interrupted answer were still used
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return ClassifyError(provider.Name(), err)
+	}
+
+	if reporter, ok := reader.(UsageReporter); ok {
+		usage := reporter.Usage()
+		if req.ShowUsage {
+			fmt.Fprintln(os.Stderr, FormatUsage(usage, req.Pricing))
+		}
+		if req.OnUsage != nil {
+			req.OnUsage(usage)
+		}
+	}
+	if err != nil {
+		return ClassifyError(provider.Name(), err)
+	}
+	return nil
+}
+
+// printAnswer copies the answer to stdout, printing a stream in chunks of
+// words
+func printAnswer(reader io.Reader, stream bool) error {
 	if !stream {
-		_, err = io.Copy(os.Stdout, reader)
+		_, err := io.Copy(os.Stdout, reader)
 		return err
 	}
 
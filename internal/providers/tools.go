@@ -0,0 +1,97 @@
+// internal/providers/tools.go
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/acazau/shell-ask-go/pkg/chat"
+)
+
+// Tool describes a function the model may ask to call
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  []ToolParameter
+}
+
+// ToolParameter is a parameter of a tool. Type is a JSON schema type such as
+// string, integer, number or boolean.
+type ToolParameter struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// schema returns the JSON schema of the tool parameters
+func (t Tool) schema() map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	for _, param := range t.Parameters {
+		properties[param.Name] = map[string]interface{}{
+			"type":        param.Type,
+			"description": param.Description,
+		}
+		if param.Required {
+			required = append(required, param.Name)
+		}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// ToolResponse is one turn of a model given tools: the tool calls it asks
+// for, or the final answer when there are none
+type ToolResponse struct {
+	Content   string
+	ToolCalls []chat.ToolCall
+	Usage     Usage
+}
+
+// ToolCaller is implemented by providers whose models can call tools. The
+// request is sent with its Tools and answered without streaming.
+type ToolCaller interface {
+	CompleteWithTools(ctx context.Context, req *Request) (*ToolResponse, error)
+}
+
+// ParseToolArguments decodes the JSON arguments of a tool call. A call
+// without arguments gets an empty map.
+func ParseToolArguments(call chat.ToolCall) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	if call.Arguments == "" {
+		return args, nil
+	}
+	if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments for tool %s: %w", call.Name, err)
+	}
+	return args, nil
+}
+
+// toolArguments encodes tool call arguments decoded by an SDK
+func toolArguments(args map[string]interface{}) string {
+	if args == nil {
+		return "{}"
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// toolNames maps the IDs of the tool calls in the messages to the tool
+// names, for APIs that match results to calls by name
+func toolNames(messages []chat.Message) map[string]string {
+	names := make(map[string]string)
+	for _, msg := range messages {
+		for _, call := range msg.ToolCalls {
+			names[call.ID] = call.Name
+		}
+	}
+	return names
+}
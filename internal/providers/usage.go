@@ -0,0 +1,123 @@
+// internal/providers/usage.go
+package providers
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acazau/shell-ask-go/internal/config"
+)
+
+// Usage is the token accounting of a completed request
+type Usage struct {
+	// Model is the model that actually answered, as reported by the API
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	// CachedTokens is the part of InputTokens served from a prompt cache
+	CachedTokens int
+	FinishReason string
+	// Latency is the time from sending the request to the end of the answer
+	Latency time.Duration
+}
+
+// Add adds the usage of another request, such as a later turn of the same
+// conversation
+func (u *Usage) Add(other Usage) {
+	u.Model = other.Model
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CachedTokens += other.CachedTokens
+	u.FinishReason = other.FinishReason
+	u.Latency += other.Latency
+}
+
+// UsageReporter is implemented by the readers returned from Complete. The
+// usage is complete once the reader has been read to the end.
+type UsageReporter interface {
+	Usage() Usage
+}
+
+// response wraps a provider's answer and collects its usage while it is read
+type response struct {
+	io.ReadCloser
+	start time.Time
+
+	mu    sync.Mutex
+	usage Usage
+	done  bool
+}
+
+// newResponse wraps body, defaulting the reported model to model
+func newResponse(body io.ReadCloser, model string, start time.Time) *response {
+	return &response{
+		ReadCloser: body,
+		start:      start,
+		usage:      Usage{Model: model},
+	}
+}
+
+func (r *response) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.finish()
+	}
+	return n, err
+}
+
+// finish records the latency the first time the answer ends
+func (r *response) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.done {
+		r.usage.Latency = time.Since(r.start)
+		r.done = true
+	}
+}
+
+// update applies fn to the collected usage. It is safe to call from the
+// goroutine producing the answer.
+func (r *response) update(fn func(u *Usage)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.usage)
+}
+
+// Usage returns the usage collected so far
+func (r *response) Usage() Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usage := r.usage
+	if !r.done {
+		usage.Latency = time.Since(r.start)
+	}
+	return usage
+}
+
+// FormatUsage renders usage as a one-line footer, with the estimated cost
+// when the pricing is known
+func FormatUsage(usage Usage, pricing *config.Pricing) string {
+	parts := []string{
+		fmt.Sprintf("%d input", usage.InputTokens),
+		fmt.Sprintf("%d output tokens", usage.OutputTokens),
+	}
+	if usage.CachedTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d cached", usage.CachedTokens))
+	}
+	if usage.FinishReason != "" {
+		parts = append(parts, "finish: "+usage.FinishReason)
+	}
+	if pricing != nil {
+		parts = append(parts, fmt.Sprintf("~$%.4f", pricing.Cost(usage.InputTokens, usage.OutputTokens)))
+	}
+	parts = append(parts, usage.Latency.Round(time.Millisecond).String())
+
+	footer := strings.Join(parts, ", ")
+	if usage.Model != "" {
+		footer = usage.Model + ": " + footer
+	}
+	return footer
+}
@@ -0,0 +1,301 @@
+// internal/schema/schema.go
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Parse returns the JSON Schema for a --type value. The value is a JSON
+// Schema, a TypeScript-like type such as "{name: string, tags: string[]}",
+// or either of them in the file named after an @.
+func Parse(spec string) (map[string]interface{}, error) {
+	if path, ok := strings.CutPrefix(spec, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read type file: %w", err)
+		}
+		spec = string(data)
+	}
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty type")
+	}
+
+	var schema map[string]interface{}
+	if json.Unmarshal([]byte(spec), &schema) == nil {
+		return schema, nil
+	}
+
+	p := &parser{input: spec}
+	schema, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return schema, nil
+}
+
+// Instruction asks the model to answer with JSON matching the schema
+func Instruction(schema map[string]interface{}) string {
+	data, _ := json.Marshal(schema)
+	return fmt.Sprintf("Respond only with a JSON value matching this JSON Schema, without Markdown or any other text:\n%s", data)
+}
+
+// Extract returns the JSON value of a model answer, which may be wrapped in
+// a Markdown code block or surrounded by text
+func Extract(answer string) (json.RawMessage, error) {
+	text := strings.TrimSpace(answer)
+	if strings.HasPrefix(text, "```") {
+		// Drop the fence lines, including the language of the opening one
+		if i := strings.Index(text, "\n"); i >= 0 {
+			text = text[i+1:]
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+	if json.Valid([]byte(text)) {
+		return json.RawMessage(text), nil
+	}
+
+	start := strings.IndexAny(text, "{[")
+	end := strings.LastIndexAny(text, "}]")
+	if start >= 0 && end > start && json.Valid([]byte(text[start:end+1])) {
+		return json.RawMessage(text[start : end+1]), nil
+	}
+
+	var value interface{}
+	err := json.Unmarshal([]byte(text), &value)
+	return nil, fmt.Errorf("the answer is not valid JSON: %w", err)
+}
+
+// parser reads TypeScript-like types:
+//
+//	type    = element { "|" element }
+//	element = primary { "[]" }
+//	primary = name | "Array<" type ">" | object | string literal | "(" type ")"
+//	object  = "{" { key [ "?" ] ":" type [ "," | ";" ] } "}"
+//
+// where name is string, number, integer, boolean, null, object or any.
+type parser struct {
+	input string
+	pos   int
+}
+
+var namedTypes = map[string]map[string]interface{}{
+	"string":  {"type": "string"},
+	"number":  {"type": "number"},
+	"integer": {"type": "integer"},
+	"boolean": {"type": "boolean"},
+	"null":    {"type": "null"},
+	"object":  {"type": "object"},
+	"any":     {},
+}
+
+func (p *parser) parseType() (map[string]interface{}, error) {
+	var alternatives []map[string]interface{}
+	for {
+		element, err := p.parseElement()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, element)
+		p.skipSpace()
+		if !p.consume("|") {
+			break
+		}
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+
+	// A union of string literals is an enum
+	var values []interface{}
+	for _, alternative := range alternatives {
+		enum, ok := alternative["enum"].([]interface{})
+		if !ok || alternative["type"] != "string" {
+			values = nil
+			break
+		}
+		values = append(values, enum...)
+	}
+	if values != nil {
+		return map[string]interface{}{"type": "string", "enum": values}, nil
+	}
+
+	anyOf := make([]interface{}, len(alternatives))
+	for i, alternative := range alternatives {
+		anyOf[i] = alternative
+	}
+	return map[string]interface{}{"anyOf": anyOf}, nil
+}
+
+func (p *parser) parseElement() (map[string]interface{}, error) {
+	schema, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if !p.consume("[]") {
+			return schema, nil
+		}
+		schema = map[string]interface{}{"type": "array", "items": schema}
+	}
+}
+
+func (p *parser) parsePrimary() (map[string]interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return nil, p.errorf("missing type")
+	}
+
+	switch c := p.input[p.pos]; {
+	case c == '{':
+		return p.parseObject()
+	case c == '(':
+		p.pos++
+		schema, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume(")") {
+			return nil, p.errorf("missing )")
+		}
+		return schema, nil
+	case c == '"' || c == '\'':
+		literal, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "string", "enum": []interface{}{literal}}, nil
+	}
+
+	name := p.parseName()
+	if name == "Array" {
+		p.skipSpace()
+		if !p.consume("<") {
+			return nil, p.errorf("missing < after Array")
+		}
+		items, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume(">") {
+			return nil, p.errorf("missing >")
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	}
+
+	named, ok := namedTypes[name]
+	if !ok {
+		if name == "" {
+			return nil, p.errorf("unexpected %q", p.input[p.pos:p.pos+1])
+		}
+		return nil, p.errorf("unknown type %q", name)
+	}
+	// Copy, as the schema may be extended by the caller
+	schema := make(map[string]interface{}, len(named))
+	for key, value := range named {
+		schema[key] = value
+	}
+	return schema, nil
+}
+
+func (p *parser) parseObject() (map[string]interface{}, error) {
+	p.pos++ // {
+	properties := make(map[string]interface{})
+	required := []interface{}{}
+	for {
+		p.skipSpace()
+		if p.consume("}") {
+			break
+		}
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("missing }")
+		}
+
+		var key string
+		if c := p.input[p.pos]; c == '"' || c == '\'' {
+			var err error
+			if key, err = p.parseString(); err != nil {
+				return nil, err
+			}
+		} else if key = p.parseName(); key == "" {
+			return nil, p.errorf("expected a property name")
+		}
+
+		p.skipSpace()
+		optional := p.consume("?")
+		p.skipSpace()
+		if !p.consume(":") {
+			return nil, p.errorf("missing : after %s", key)
+		}
+		value, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		properties[key] = value
+		if !optional {
+			required = append(required, key)
+		}
+
+		p.skipSpace()
+		if !p.consume(",") {
+			p.consume(";")
+		}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}, nil
+}
+
+func (p *parser) parseName() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		r := rune(p.input[p.pos])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '$' {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *parser) parseString() (string, error) {
+	quote := p.input[p.pos]
+	end := strings.IndexByte(p.input[p.pos+1:], quote)
+	if end < 0 {
+		return "", p.errorf("unterminated string")
+	}
+	value := p.input[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return value, nil
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) consume(token string) bool {
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid type at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,106 @@
+// internal/schema/schema_test.go
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTypeScriptType(t *testing.T) {
+	schema, err := Parse(`{name: string, tags: string[]; age?: integer, kind: "file" | "dir", meta: {size: number} | null}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, _ := json.Marshal(schema)
+	want := `{"properties":{"age":{"type":"integer"},"kind":{"enum":["file","dir"],"type":"string"},` +
+		`"meta":{"anyOf":[{"properties":{"size":{"type":"number"}},"required":["size"],"type":"object"},{"type":"null"}]},` +
+		`"name":{"type":"string"},"tags":{"items":{"type":"string"},"type":"array"}},` +
+		`"required":["name","tags","kind","meta"],"type":"object"}`
+	if string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	if _, err := Parse("{name: strin}"); err == nil || !strings.Contains(err.Error(), `unknown type "strin"`) {
+		t.Errorf("expected an unknown type error, got %v", err)
+	}
+	if _, err := Parse("{name: string"); err == nil {
+		t.Error("expected an error for an unterminated object")
+	}
+}
+
+func TestParseJSONSchemaFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	os.WriteFile(path, []byte(`{"type": "array", "items": {"type": "string"}}`), 0644)
+
+	schema, err := Parse("@" + path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if schema["type"] != "array" {
+		t.Errorf("expected the JSON Schema to be used as is, got %v", schema)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	tests := map[string]string{
+		`{"a": 1}`:                          `{"a": 1}`,
+		"```json\n[1, 2]\n```":              `[1, 2]`,
+		"Here you go:\n{\"a\": [1]}\nDone.": `{"a": [1]}`,
+	}
+	for answer, want := range tests {
+		got, err := Extract(answer)
+		if err != nil || string(got) != want {
+			t.Errorf("Extract(%q): expected %s, got %s (%v)", answer, want, got, err)
+		}
+	}
+
+	if _, err := Extract("no json here"); err == nil {
+		t.Error("expected an error for an answer without JSON")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema, _ := Parse(`{name: string, tags: string[], size?: integer, kind: "file" | "dir"}`)
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"name": "a", "tags": ["x"], "size": 3, "kind": "dir"}`, ""},
+		{`{"name": "a", "tags": ["x"], "kind": "file", "extra": true}`, ""},
+		{`{"tags": [], "kind": "file"}`, "$: missing property name"},
+		{`{"name": "a", "tags": ["x", 2], "kind": "file"}`, "$.tags[1]: expected string, got integer"},
+		{`{"name": "a", "tags": [], "size": 1.5, "kind": "file"}`, "$.size: expected integer, got number"},
+		{`{"name": "a", "tags": [], "kind": "link"}`, `$.kind: "link" is not one of ["file","dir"]`},
+		{`[]`, "$: expected object, got array"},
+	}
+	for _, tt := range tests {
+		err := Validate(schema, []byte(tt.data))
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.data, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: expected error %q, got %v", tt.data, tt.err, err)
+		}
+	}
+
+	oneOf := map[string]interface{}{"oneOf": []interface{}{
+		map[string]interface{}{"type": "integer"},
+		map[string]interface{}{"type": "number"},
+	}}
+	if err := Validate(oneOf, []byte(`1.5`)); err != nil {
+		t.Errorf("expected a value matching one alternative to pass, got %v", err)
+	}
+	if err := Validate(oneOf, []byte(`2`)); err == nil || err.Error() != "$: matches 2 of the allowed types instead of exactly one" {
+		t.Errorf("expected a value matching both alternatives of oneOf to be rejected, got %v", err)
+	}
+
+	strict := map[string]interface{}{"type": "object", "additionalProperties": false}
+	if err := Validate(strict, []byte(`{"a": 1}`)); err == nil {
+		t.Error("expected additional properties to be rejected")
+	}
+}
@@ -0,0 +1,174 @@
+// internal/schema/validate.go
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Validate checks that the JSON data matches the schema. It supports the
+// keywords type, properties, required, additionalProperties, items, enum,
+// const, anyOf and oneOf, which covers the types Parse produces.
+func Validate(schema map[string]interface{}, data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return validate(schema, value, "$")
+}
+
+func validate(schema map[string]interface{}, value interface{}, path string) error {
+	if alternatives, ok := schema["anyOf"].([]interface{}); ok && countMatches(alternatives, value, path) == 0 {
+		return fmt.Errorf("%s: matches none of the allowed types", path)
+	}
+	if alternatives, ok := schema["oneOf"].([]interface{}); ok {
+		switch n := countMatches(alternatives, value, path); n {
+		case 0:
+			return fmt.Errorf("%s: matches none of the allowed types", path)
+		case 1:
+		default:
+			return fmt.Errorf("%s: matches %d of the allowed types instead of exactly one", path, n)
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && !contains(enum, value) {
+		return fmt.Errorf("%s: %s is not one of %s", path, describe(value), describe(enum))
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, describe(constant), describe(value))
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !matchesType(types, value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonType(value))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return validateObject(schema, v, path)
+	case []interface{}:
+		items, ok := schema["items"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range v {
+			if err := validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateObject(schema map[string]interface{}, object map[string]interface{}, path string) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, key := range required {
+			name, _ := key.(string)
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing property %s", path, name)
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	// Sorted, so the same answer always reports the same error
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		property, ok := properties[key].(map[string]interface{})
+		if !ok {
+			if allowed, ok := schema["additionalProperties"].(bool); ok && !allowed {
+				return fmt.Errorf("%s: unexpected property %s", path, key)
+			}
+			continue
+		}
+		if err := validate(property, object[key], path+"."+key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countMatches returns the number of alternatives the value matches
+func countMatches(alternatives []interface{}, value interface{}, path string) int {
+	var n int
+	for _, alternative := range alternatives {
+		if schema, ok := alternative.(map[string]interface{}); ok && validate(schema, value, path) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// schemaTypes returns the types of a type keyword, a name or a list of names
+func schemaTypes(keyword interface{}) []string {
+	switch t := keyword.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, name := range t {
+			if s, ok := name.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesType(types []string, value interface{}) bool {
+	actual := jsonType(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON type of a decoded value. Whole numbers are
+// integers.
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func contains(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -7,14 +7,46 @@ import (
 	"path/filepath"
 )
 
+// Message roles understood by every provider
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	// RoleTool messages carry the result of a tool call
+	RoleTool = "tool"
+)
+
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls are the tools an assistant message asks to call
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID is the call a tool message answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// Images are attached to a user message
+	Images []Image `json:"images,omitempty"`
+}
+
+// Image is an image attached to a message
+type Image struct {
+	// MIMEType is image/png, image/jpeg, image/gif or image/webp
+	MIMEType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+// ToolCall is a request of the model to call a tool
+type ToolCall struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Arguments is the JSON object of arguments
+	Arguments string `json:"arguments"`
 }
 
 type Chat struct {
 	Messages []Message `json:"messages"`
 	Model    string    `json:"model"`
+	// Interrupted marks a conversation whose last answer was cut short
+	Interrupted bool `json:"interrupted,omitempty"`
 }
 
 func SaveChat(chat *Chat) error {
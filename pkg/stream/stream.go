@@ -3,12 +3,19 @@ package stream
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 )
 
+// ErrDone is returned by a StreamProcessor when the stream signals its end
+var ErrDone = errors.New("stream done")
+
+// maxEventSize is the largest single SSE line the scanner accepts
+const maxEventSize = 1024 * 1024
 
 type StreamProcessor interface {
 	ProcessChunk([]byte) (string, error)
@@ -22,16 +29,24 @@ type openAIResponse struct {
 			Content string `json:"content"`
 		} `json:"delta"`
 	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
 }
 
 func (p *OpenAIStreamProcessor) ProcessChunk(chunk []byte) (string, error) {
+	// Remove "data: " prefix if present
+	data := strings.TrimSpace(strings.TrimPrefix(string(chunk), "data: "))
+
 	// Skip empty lines
-	if len(chunk) == 0 {
+	if data == "" {
 		return "", nil
 	}
 
-	// Remove "data: " prefix if present
-	data := strings.TrimPrefix(string(chunk), "data: ")
+	if data == "[DONE]" {
+		return "", ErrDone
+	}
 
 	// Parse JSON
 	var resp openAIResponse
@@ -39,6 +54,10 @@ func (p *OpenAIStreamProcessor) ProcessChunk(chunk []byte) (string, error) {
 		return "", err
 	}
 
+	if resp.Error != nil {
+		return "", fmt.Errorf("stream error: %s", resp.Error.Message)
+	}
+
 	if len(resp.Choices) == 0 {
 		return "", nil
 	}
@@ -46,11 +65,29 @@ func (p *OpenAIStreamProcessor) ProcessChunk(chunk []byte) (string, error) {
 	return resp.Choices[0].Delta.Content, nil
 }
 
-// Process a stream and write it to the output writer
+// Process a server-sent event stream and write it to the output writer.
+// The data lines of an event are joined and handed to the processor once the
+// event is complete. Comment lines (keep-alives) are ignored and events named
+// "error" abort the stream.
 func ProcessStream(reader io.Reader, writer io.Writer, processor StreamProcessor) error {
 	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		text, err := processor.ProcessChunk(scanner.Bytes())
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+
+	var event string
+	var data bytes.Buffer
+
+	dispatch := func() error {
+		defer func() {
+			event = ""
+			data.Reset()
+		}()
+		if data.Len() == 0 {
+			return nil
+		}
+		if event == "error" {
+			return fmt.Errorf("stream error: %s", data.String())
+		}
+		text, err := processor.ProcessChunk(data.Bytes())
 		if err != nil {
 			return err
 		}
@@ -59,7 +96,60 @@ func ProcessStream(reader io.Reader, writer io.Writer, processor StreamProcessor
 				return err
 			}
 		}
+		return nil
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		var field, value string
+		switch {
+		case line == "":
+			// A blank line terminates the current event
+			if err := dispatch(); err != nil {
+				return ignoreDone(err)
+			}
+			continue
+		case strings.HasPrefix(line, ":"):
+			// Comment, used by servers as keep-alive
+			continue
+		case strings.Contains(line, ":"):
+			field, value, _ = strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+		default:
+			field = line
+		}
+
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			// Some servers omit the blank line between events, so a buffered
+			// payload that is already complete JSON is dispatched on its own
+			if data.Len() > 0 && json.Valid(data.Bytes()) {
+				pending := event
+				if err := dispatch(); err != nil {
+					return ignoreDone(err)
+				}
+				event = pending
+			}
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
 	}
-	return scanner.Err()
+
+	return ignoreDone(dispatch())
 }
 
+// ignoreDone treats the end-of-stream marker as a successful finish
+func ignoreDone(err error) error {
+	if errors.Is(err, ErrDone) {
+		return nil
+	}
+	return err
+}
@@ -91,3 +91,59 @@ data: {"choices":[{"delta":{"content":" World!"}}]}
 		})
 	}
 }
+
+func TestProcessStreamEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name: "done marker stops the stream",
+			input: "data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+				"data: [DONE]\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"ignored\"}}]}\n\n",
+			expected: "Hello",
+		},
+		{
+			name: "keep-alive comments are ignored",
+			input: ": ping\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+				": ping\n\n",
+			expected: "Hello",
+		},
+		{
+			name: "multi-line event",
+			input: "data: {\"choices\":[{\"delta\":\n" +
+				"data: {\"content\":\"Hello\"}}]}\n\n",
+			expected: "Hello",
+		},
+		{
+			name: "error event",
+			input: "data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+				"event: error\ndata: overloaded\n\n",
+			expected: "Hello",
+			wantErr:  true,
+		},
+		{
+			name:     "error payload",
+			input:    "data: {\"error\":{\"message\":\"rate limited\"}}\n\n",
+			expected: "",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var writer bytes.Buffer
+			err := ProcessStream(bytes.NewBufferString(tt.input), &writer, &OpenAIStreamProcessor{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ProcessStream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if writer.String() != tt.expected {
+				t.Errorf("ProcessStream() got = %v, want %v", writer.String(), tt.expected)
+			}
+		})
+	}
+}
@@ -23,10 +23,10 @@ func ReadFiles(files []string) (string, error) {
 	return strings.Join(contents, "\n\n"), nil
 }
 
-// FetchURLs fetches content from multiple URLs and returns their combined content
-func FetchURLs(urls []string) (string, error) {
+// FetchURLs fetches content from multiple URLs with client and returns their
+// combined content
+func FetchURLs(client *http.Client, urls []string) (string, error) {
 	var contents []string
-	client := &http.Client{}
 
 	for _, url := range urls {
 		req, err := http.NewRequest("GET", url, nil)
@@ -0,0 +1,88 @@
+// pkg/utils/images.go
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/acazau/shell-ask-go/pkg/chat"
+)
+
+// maxImageSize is the largest image accepted, the limit of the Anthropic
+// and OpenAI APIs
+const maxImageSize = 20 * 1024 * 1024
+
+// imageTypes are the image formats every vision API accepts
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// ReadImages loads images from file paths or http(s) URLs, which are
+// fetched with client
+func ReadImages(client *http.Client, sources []string) ([]chat.Image, error) {
+	var images []chat.Image
+	for _, source := range sources {
+		source = strings.TrimSpace(source)
+		var data []byte
+		var err error
+		if IsURL(source) {
+			data, err = fetchImage(client, source)
+		} else {
+			data, err = readImageFile(filepath.Clean(source))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image %s: %w", source, err)
+		}
+		if len(data) > maxImageSize {
+			return nil, fmt.Errorf("image %s is larger than %d MB", source, maxImageSize/1024/1024)
+		}
+
+		// The content is trusted over file extensions and server headers
+		mimeType := http.DetectContentType(data)
+		if !imageTypes[mimeType] {
+			return nil, fmt.Errorf("unsupported image %s: %s, use PNG, JPEG, GIF or WebP", source, mimeType)
+		}
+		images = append(images, chat.Image{MIMEType: mimeType, Data: data})
+	}
+	return images, nil
+}
+
+// IsURL reports whether source is an http(s) URL rather than a file path
+func IsURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+func readImageFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, maxImageSize+1))
+}
+
+func fetchImage(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Shell-Ask-Go/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+}
@@ -0,0 +1,38 @@
+// pkg/utils/images_test.go
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pngHeader is enough of a PNG file for content sniffing
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestReadImages(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "screenshot.png")
+	os.WriteFile(path, pngHeader, 0644)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngHeader)
+	}))
+	defer server.Close()
+
+	images, err := ReadImages(server.Client(), []string{path, server.URL + "/image"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(images) != 2 || images[0].MIMEType != "image/png" || images[1].MIMEType != "image/png" {
+		t.Errorf("expected two PNG images, got %+v", images)
+	}
+
+	text := filepath.Join(dir, "notes.png")
+	os.WriteFile(text, []byte("just text"), 0644)
+	if _, err := ReadImages(http.DefaultClient, []string{text}); err == nil {
+		t.Error("expected a text file to be rejected")
+	}
+}
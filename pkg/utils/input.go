@@ -38,3 +38,21 @@ func SelectOption(question string, options []string) (string, error) {
 	}
 	return options[choice-1], nil
 }
+
+// AskYesNoTTY asks a yes/no question on the controlling terminal, which
+// works while stdin is a pipe. It fails when there is no terminal.
+func AskYesNoTTY(question string) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%s (y/n): ", question)
+	response, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	response = strings.ToLower(strings.TrimSpace(response))
+	return response == "y" || response == "yes", nil
+}